@@ -8,8 +8,11 @@ import (
 	"net/http"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 
+	"auth"
+
 	_ "github.com/go-sql-driver/mysql"
 	"github.com/gorilla/mux"
 	"github.com/rs/cors"
@@ -48,31 +51,41 @@ func main() {
 
 	// Initialize the router
 	router := mux.NewRouter()
+	verifier := &auth.Verifier{Secret: auth.SecretFromEnv(), CareTeamURL: "http://localhost:5004"}
 
 	// API Routes
-	router.HandleFunc("/api/getNotifications", func(w http.ResponseWriter, r *http.Request) {
+	router.HandleFunc("/api/getNotifications", verifier.Require(func(w http.ResponseWriter, r *http.Request) {
 		notificationHandler(w, r, db)
-	}).Methods("POST")
-	router.HandleFunc("/api/postNotifications", func(w http.ResponseWriter, r *http.Request) {
+	})).Methods("POST")
+	// Notifications and alerts are raised by other services, with a service token
+	router.HandleFunc("/api/postNotifications", verifier.RequirePermission(auth.PermPostNotifications, func(w http.ResponseWriter, r *http.Request) {
 		postHandler(w, r, db)
-	}).Methods("POST")
+	})).Methods("POST")
 
 	// Alerts are for Doctors
-	router.HandleFunc("/api/getAlerts", func(w http.ResponseWriter, r *http.Request) {
+	router.HandleFunc("/api/getAlerts", verifier.RequirePermission(auth.PermViewAlerts, func(w http.ResponseWriter, r *http.Request) {
 		doctorNotificationHandler(w, r, db)
-	}).Methods("GET")
-	router.HandleFunc("/api/postAlerts", func(w http.ResponseWriter, r *http.Request) {
+	})).Methods("GET")
+	router.HandleFunc("/api/postAlerts", verifier.RequirePermission(auth.PermPostNotifications, func(w http.ResponseWriter, r *http.Request) {
 		doctorPostHandler(w, r, db)
-	}).Methods("POST")
-	router.HandleFunc("/api/resolveAlerts/{assessment_id}", func(w http.ResponseWriter, r *http.Request) {
+	})).Methods("POST")
+	router.HandleFunc("/api/resolveAlerts/{assessment_id}", verifier.RequirePermission(auth.PermResolveAlerts, func(w http.ResponseWriter, r *http.Request) {
 		doctorResolveHandler(w, r, db)
-	}).Methods("DELETE")
+	})).Methods("DELETE")
+
+	// Personal data requests, called by the User service with the patient's token
+	router.HandleFunc("/api/exportUserData", verifier.RequirePermission(auth.PermManageOwnData, func(w http.ResponseWriter, r *http.Request) {
+		exportUserDataHandler(w, r, db)
+	})).Methods("GET")
+	router.HandleFunc("/api/deleteUserData", verifier.RequirePermission(auth.PermManageOwnData, func(w http.ResponseWriter, r *http.Request) {
+		deleteUserDataHandler(w, r, db)
+	})).Methods("DELETE")
 
 	// CORS Configuration
 	c := cors.New(cors.Options{
 		AllowedOrigins:   []string{"*"},
 		AllowedMethods:   []string{"GET", "POST", "DELETE"},
-		AllowedHeaders:   []string{"Content-Type"},
+		AllowedHeaders:   []string{"Content-Type", "Authorization"},
 		AllowCredentials: true,
 	})
 	handler := c.Handler(router)
@@ -89,23 +102,24 @@ func notificationHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
 	}
 	var req Request
 
-	// Decode JSON request
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		log.Println("Invalid JSON request")
-		http.Error(w, "Invalid JSON request", http.StatusBadRequest)
-		return
+	// Decode JSON request (optional for patients, who always get their own notifications)
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			log.Println("Invalid JSON request")
+			http.Error(w, "Invalid JSON request", http.StatusBadRequest)
+			return
+		}
 	}
 
-	// Validate User ID
-	if req.UserID <= 0 {
-		log.Println("Invalid or missing User ID")
-		http.Error(w, "Invalid or missing user_id", http.StatusBadRequest)
+	// Resolve and authorise the patient from the access token
+	userID, ok := auth.PatientID(w, r, req.UserID)
+	if !ok {
 		return
 	}
 
 	// Query database for user notifications
 	query := `SELECT NotificationID, Message, SentAt FROM Notifications WHERE UserID = ? ORDER BY SentAt DESC`
-	rows, err := db.Query(query, req.UserID)
+	rows, err := db.Query(query, userID)
 	if err != nil {
 		log.Println("Database query error:", err)
 		http.Error(w, "Failed to fetch notifications", http.StatusInternalServerError)
@@ -183,9 +197,31 @@ func postHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
 }
 
 func doctorNotificationHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
+	// Only alerts for patients on the caller's care team are returned
+	caller, _ := auth.CallerFromContext(r.Context())
+	userIDs, err := caller.AssignedPatients()
+	if err != nil {
+		log.Println("Care team lookup error:", err)
+		http.Error(w, "Failed to fetch alerts", http.StatusInternalServerError)
+		return
+	}
+	if len(userIDs) == 0 {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode([]interface{}{})
+		return
+	}
+
+	placeholders := strings.TrimSuffix(strings.Repeat("?,", len(userIDs)), ",")
+	args := make([]interface{}, len(userIDs))
+	for i, id := range userIDs {
+		args[i] = id
+	}
+
 	// Query database for alerts
-	query := `SELECT AlertID, AssessmentID, SentAt FROM Alerts WHERE SentAt >= NOW() - INTERVAL 3 DAY ORDER BY SentAt DESC`
-	rows, err := db.Query(query)
+	query := `SELECT AlertID, AssessmentID, UserID, IFNULL(Reason, ''), SentAt FROM Alerts
+              WHERE SentAt >= NOW() - INTERVAL 3 DAY AND UserID IN (` + placeholders + `)
+              ORDER BY SentAt DESC`
+	rows, err := db.Query(query, args...)
 	if err != nil {
 		log.Println("Database query error:", err)
 		http.Error(w, "Failed to fetch alerts", http.StatusInternalServerError)
@@ -197,10 +233,11 @@ func doctorNotificationHandler(w http.ResponseWriter, r *http.Request, db *sql.D
 
 	// Iterate over rows
 	for rows.Next() {
-		var alertID, assessmentID int
+		var alertID, assessmentID, userID int
+		var reason string
 		var sentAt time.Time
 
-		if err := rows.Scan(&alertID, &assessmentID, &sentAt); err != nil {
+		if err := rows.Scan(&alertID, &assessmentID, &userID, &reason, &sentAt); err != nil {
 			log.Println("Error scanning row:", err)
 			http.Error(w, "Failed to process data", http.StatusInternalServerError)
 			return
@@ -209,6 +246,8 @@ func doctorNotificationHandler(w http.ResponseWriter, r *http.Request, db *sql.D
 		alerts = append(alerts, map[string]interface{}{
 			"alert_id":      alertID,
 			"assessment_id": assessmentID,
+			"user_id":       userID,
+			"reason":        reason,
 			"sent_at":       sentAt.Format("2006-01-02 15:04:05"),
 		})
 	}
@@ -227,7 +266,9 @@ func doctorNotificationHandler(w http.ResponseWriter, r *http.Request, db *sql.D
 
 func doctorPostHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
 	type Request struct {
-		AssessmentID int `json:"assessment_id"`
+		AssessmentID int    `json:"assessment_id"`
+		UserID       int    `json:"user_id"`
+		Reason       string `json:"reason"` // Optional
 	}
 	var req Request
 
@@ -239,15 +280,20 @@ func doctorPostHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
 	}
 
 	// Validate input
-	if req.AssessmentID <= 0 {
-		log.Println("Invalid input: AssessmentID missing")
-		http.Error(w, "Invalid input: AssessmentID missing", http.StatusBadRequest)
+	if req.AssessmentID <= 0 || req.UserID <= 0 {
+		log.Println("Invalid input: AssessmentID or UserID missing")
+		http.Error(w, "Invalid input: AssessmentID or UserID missing", http.StatusBadRequest)
+		return
+	}
+
+	if len(req.Reason) > 255 {
+		http.Error(w, "Invalid input: Reason must be at most 255 characters", http.StatusBadRequest)
 		return
 	}
 
 	// Insert into database
-	query := `INSERT INTO Alerts (AssessmentID) VALUES (?)`
-	_, err := db.Exec(query, req.AssessmentID)
+	query := `INSERT INTO Alerts (AssessmentID, UserID, Reason) VALUES (?, ?, NULLIF(?, ''))`
+	_, err := db.Exec(query, req.AssessmentID, req.UserID, req.Reason)
 	if err != nil {
 		log.Println("Database insert error:", err)
 		http.Error(w, "Failed to store alert", http.StatusInternalServerError)
@@ -281,6 +327,30 @@ func doctorResolveHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
 		return
 	}
 
+	// Only a doctor on the patient's care team may resolve the alert
+	var userID int
+	err = db.QueryRow(`SELECT UserID FROM Alerts WHERE AssessmentID = ? LIMIT 1`, assessmentID).Scan(&userID)
+	if err == sql.ErrNoRows {
+		http.Error(w, "No alert found for the given assessment ID", http.StatusNotFound)
+		return
+	} else if err != nil {
+		log.Println("Database query error:", err)
+		http.Error(w, "Failed to resolve alert", http.StatusInternalServerError)
+		return
+	}
+
+	caller, _ := auth.CallerFromContext(r.Context())
+	allowed, err := caller.CanAccessPatient(userID)
+	if err != nil {
+		log.Println("Care team lookup error:", err)
+		http.Error(w, "Failed to resolve alert", http.StatusInternalServerError)
+		return
+	}
+	if !allowed {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
 	// Delete the alert from the database
 	query := `DELETE FROM Alerts WHERE AssessmentID = ?`
 	result, err := db.Exec(query, assessmentID)
@@ -13,5 +13,7 @@ CREATE TABLE Notifications (
 CREATE TABLE Alerts (
     AlertID INT AUTO_INCREMENT PRIMARY KEY,
     AssessmentID INT NOT NULL,
+    UserID INT NOT NULL,
+    Reason VARCHAR(255) NULL, -- Why the alert was raised, e.g. high risk or a deteriorating trend
     SentAt TIMESTAMP DEFAULT CURRENT_TIMESTAMP
 );
\ No newline at end of file
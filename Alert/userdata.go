@@ -0,0 +1,111 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"auth"
+)
+
+// Return every notification and alert stored for the authenticated patient. Called by the User service's data export.
+func exportUserDataHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
+	caller, _ := auth.CallerFromContext(r.Context())
+
+	type notification struct {
+		NotificationID int    `json:"notification_id"`
+		Message        string `json:"message"`
+		SentAt         string `json:"sent_at"`
+	}
+	type alert struct {
+		AlertID      int    `json:"alert_id"`
+		AssessmentID int    `json:"assessment_id"`
+		Reason       string `json:"reason,omitempty"`
+		SentAt       string `json:"sent_at"`
+	}
+
+	notifications := []notification{}
+	rows, err := db.Query("SELECT NotificationID, Message, SentAt FROM Notifications WHERE UserID = ? ORDER BY SentAt", caller.ID)
+	if err != nil {
+		log.Println("Database query error:", err)
+		http.Error(w, "Failed to fetch data", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var n notification
+		var sentAt time.Time
+		if err := rows.Scan(&n.NotificationID, &n.Message, &sentAt); err != nil {
+			log.Println("Error scanning row:", err)
+			http.Error(w, "Failed to process data", http.StatusInternalServerError)
+			return
+		}
+		n.SentAt = sentAt.Format("2006-01-02 15:04:05")
+		notifications = append(notifications, n)
+	}
+
+	alerts := []alert{}
+	alertRows, err := db.Query("SELECT AlertID, AssessmentID, IFNULL(Reason, ''), SentAt FROM Alerts WHERE UserID = ? ORDER BY SentAt", caller.ID)
+	if err != nil {
+		log.Println("Database query error:", err)
+		http.Error(w, "Failed to fetch data", http.StatusInternalServerError)
+		return
+	}
+	defer alertRows.Close()
+	for alertRows.Next() {
+		var a alert
+		var sentAt time.Time
+		if err := alertRows.Scan(&a.AlertID, &a.AssessmentID, &a.Reason, &sentAt); err != nil {
+			log.Println("Error scanning row:", err)
+			http.Error(w, "Failed to process data", http.StatusInternalServerError)
+			return
+		}
+		a.SentAt = sentAt.Format("2006-01-02 15:04:05")
+		alerts = append(alerts, a)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"notifications": notifications,
+		"alerts":        alerts,
+	})
+}
+
+// Delete every notification and alert stored for the authenticated patient. Called by the User service when an account is deleted.
+func deleteUserDataHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
+	caller, _ := auth.CallerFromContext(r.Context())
+
+	tx, err := db.Begin()
+	if err != nil {
+		log.Println("Database transaction error:", err)
+		http.Error(w, "Failed to delete data", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	notifications, err := tx.Exec("DELETE FROM Notifications WHERE UserID = ?", caller.ID)
+	if err != nil {
+		log.Println("Database delete error:", err)
+		http.Error(w, "Failed to delete data", http.StatusInternalServerError)
+		return
+	}
+	alerts, err := tx.Exec("DELETE FROM Alerts WHERE UserID = ?", caller.ID)
+	if err != nil {
+		log.Println("Database delete error:", err)
+		http.Error(w, "Failed to delete data", http.StatusInternalServerError)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		log.Println("Database commit error:", err)
+		http.Error(w, "Failed to delete data", http.StatusInternalServerError)
+		return
+	}
+
+	deletedNotifications, _ := notifications.RowsAffected()
+	deletedAlerts, _ := alerts.RowsAffected()
+	log.Printf("Deleted %d notifications and %d alerts for user %d\n", deletedNotifications, deletedAlerts, caller.ID)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Notification data deleted"})
+}
@@ -0,0 +1,91 @@
+package auth
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// How long another service trusts the Doctor service's answer that a staff account is active.
+// A deactivated doctor's tokens stop working everywhere within this time.
+const accountStatusTTL = 30 * time.Second
+
+type accountStatus struct {
+	active    bool
+	checkedAt time.Time
+}
+
+// Remembered account status per token, shared by all Verifiers in the process
+var (
+	accountStatusMu    sync.Mutex
+	accountStatusCache = map[string]accountStatus{}
+)
+
+// Staff accounts (doctors, caregivers and admins) can be deactivated in the Doctor service
+func isStaffRole(role string) bool {
+	return roleIssuers[role] == IssuerDoctorService
+}
+
+// Check that the caller's account hasn't been deactivated since the token was issued. Only staff
+// accounts are checked: with the AccountActive hook where the service owns the accounts, and
+// otherwise by asking the Doctor service at CareTeamURL.
+func (v *Verifier) accountActive(c Caller) (bool, error) {
+	if !isStaffRole(c.Role) {
+		return true, nil
+	}
+	if v.AccountActive != nil {
+		return v.AccountActive(c.ID)
+	}
+	if v.CareTeamURL == "" {
+		return true, nil
+	}
+
+	accountStatusMu.Lock()
+	status, ok := accountStatusCache[c.TokenID]
+	accountStatusMu.Unlock()
+	if ok && time.Since(status.checkedAt) < accountStatusTTL {
+		return status.active, nil
+	}
+
+	active, err := c.remoteAccountActive()
+	if err != nil {
+		return false, err
+	}
+
+	accountStatusMu.Lock()
+	now := time.Now()
+	for tokenID, s := range accountStatusCache {
+		if now.Sub(s.checkedAt) >= accountStatusTTL {
+			delete(accountStatusCache, tokenID)
+		}
+	}
+	accountStatusCache[c.TokenID] = accountStatus{active: active, checkedAt: now}
+	accountStatusMu.Unlock()
+	return active, nil
+}
+
+// Ask the Doctor service whether the caller's token is still accepted. It answers 401 for
+// deactivated accounts.
+func (c Caller) remoteAccountActive() (bool, error) {
+	req, err := http.NewRequest(http.MethodGet, c.careTeamURL+"/api/accountStatus", nil)
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.token)
+
+	resp, err := careTeamClient.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusUnauthorized:
+		return false, nil
+	default:
+		return false, fmt.Errorf("account status check failed with status %d", resp.StatusCode)
+	}
+}
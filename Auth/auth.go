@@ -0,0 +1,251 @@
+// Package auth issues and verifies the signed access tokens shared by the User and
+// Doctor services, and provides the middleware other services use to identify the caller.
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Token issuers
+const (
+	IssuerUserService   = "user_service"
+	IssuerDoctorService = "doctor_service"
+	IssuerInternal      = "internal" // Tokens services issue to each other
+)
+
+// Service tokens only cover one call, or one batch of calls
+const serviceTokenTTL = 5 * time.Minute
+
+// Claims carried inside a signed access token
+type Claims struct {
+	AccountID int    `json:"account_id"`
+	Role      string `json:"role"`
+	jwt.RegisteredClaims
+}
+
+// Caller is the authenticated identity stored in the request context
+type Caller struct {
+	ID        int
+	Role      string
+	TokenID   string
+	ExpiresAt time.Time
+	Service   string // Calling service, for service tokens
+
+	// Raw token and Doctor service URL, used to look up care-team assignments on the caller's behalf
+	token       string
+	careTeamURL string
+}
+
+type contextKey string
+
+const callerKey contextKey = "caller"
+
+// Verifier checks access tokens signed with the shared secret
+type Verifier struct {
+	Secret []byte
+	// Optional hook for services that track tokens revoked before expiry
+	IsRevoked func(tokenID string) (bool, error)
+	// Base URL of the Doctor service, which owns care-team assignments and staff accounts
+	CareTeamURL string
+	// Optional hook for the Doctor service, which checks its own accounts instead of calling CareTeamURL
+	AccountActive func(accountID int) (bool, error)
+}
+
+// Load the shared signing secret from JWT_SECRET
+func SecretFromEnv() []byte {
+	secret := []byte(os.Getenv("JWT_SECRET"))
+	if len(secret) == 0 {
+		log.Fatal("JWT_SECRET environment variable is not set")
+	}
+	return secret
+}
+
+// Create a signed HMAC-SHA256 access token for an account
+func IssueToken(secret []byte, accountID int, role string, ttl time.Duration) (string, error) {
+	if role == RoleService {
+		return "", errors.New("service tokens are issued with IssueServiceToken")
+	}
+	return issue(secret, accountID, role, "", ttl)
+}
+
+// Create a short-lived token for a call from one service to another, such as Self Assessment
+// raising an alert. Only services holding the shared secret can create one.
+func IssueServiceToken(secret []byte, service string) (string, error) {
+	return issue(secret, 0, RoleService, service, serviceTokenTTL)
+}
+
+func issue(secret []byte, accountID int, role, subject string, ttl time.Duration) (string, error) {
+	issuer, ok := roleIssuers[role]
+	if !ok {
+		return "", errors.New("unknown role: " + role)
+	}
+
+	jti := make([]byte, 16)
+	if _, err := rand.Read(jti); err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	claims := Claims{
+		AccountID: accountID,
+		Role:      role,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        hex.EncodeToString(jti),
+			Issuer:    issuer,
+			Subject:   subject,
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
+		},
+	}
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(secret)
+}
+
+// Verify signature, expiry and issuer of an access token
+func (v *Verifier) Parse(tokenString string) (*Claims, error) {
+	claims := &Claims{}
+	token, err := jwt.ParseWithClaims(tokenString, claims, func(t *jwt.Token) (interface{}, error) {
+		return v.Secret, nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Name}), jwt.WithExpirationRequired())
+	if err != nil {
+		return nil, err
+	}
+	// Service tokens name a service instead of an account
+	if !token.Valid || (claims.AccountID <= 0 && (claims.Role != RoleService || claims.Subject == "")) {
+		return nil, errors.New("invalid token")
+	}
+	if issuer, ok := roleIssuers[claims.Role]; !ok || issuer != claims.Issuer {
+		return nil, errors.New("invalid token role")
+	}
+	return claims, nil
+}
+
+// Extract the bearer token from the Authorization header
+func BearerToken(r *http.Request) string {
+	header := r.Header.Get("Authorization")
+	if !strings.HasPrefix(header, "Bearer ") {
+		return ""
+	}
+	return strings.TrimSpace(strings.TrimPrefix(header, "Bearer "))
+}
+
+// Middleware that verifies the access token and stores the caller in the request context.
+// CORS preflight requests carry no credentials and are passed through untouched.
+func (v *Verifier) Require(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodOptions {
+			next(w, r)
+			return
+		}
+
+		tokenString := BearerToken(r)
+		if tokenString == "" {
+			http.Error(w, "Missing access token", http.StatusUnauthorized)
+			return
+		}
+
+		claims, err := v.Parse(tokenString)
+		if err != nil {
+			http.Error(w, "Invalid or expired access token", http.StatusUnauthorized)
+			return
+		}
+
+		if v.IsRevoked != nil {
+			revoked, err := v.IsRevoked(claims.ID)
+			if err != nil {
+				log.Println("Token revocation check error:", err)
+				http.Error(w, "Internal server error", http.StatusInternalServerError)
+				return
+			}
+			if revoked {
+				http.Error(w, "Invalid or expired access token", http.StatusUnauthorized)
+				return
+			}
+		}
+
+		caller := Caller{
+			ID:          claims.AccountID,
+			Role:        claims.Role,
+			TokenID:     claims.ID,
+			ExpiresAt:   claims.ExpiresAt.Time,
+			Service:     claims.Subject,
+			token:       tokenString,
+			careTeamURL: v.CareTeamURL,
+		}
+
+		// Tokens of deactivated staff accounts stop working before they expire
+		active, err := v.accountActive(caller)
+		if err != nil {
+			log.Println("Account status check error:", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		if !active {
+			http.Error(w, "Account has been deactivated", http.StatusUnauthorized)
+			return
+		}
+		next(w, r.WithContext(context.WithValue(r.Context(), callerKey, caller)))
+	}
+}
+
+// Authenticated caller set by Require
+func CallerFromContext(ctx context.Context) (Caller, bool) {
+	caller, ok := ctx.Value(callerKey).(Caller)
+	return caller, ok
+}
+
+func (c Caller) IsPatient() bool   { return c.Role == RolePatient }
+func (c Caller) IsDoctor() bool    { return c.Role == RoleDoctor }
+func (c Caller) IsCaregiver() bool { return c.Role == RoleCaregiver }
+func (c Caller) IsAdmin() bool     { return c.Role == RoleAdmin }
+func (c Caller) IsService() bool   { return c.Role == RoleService }
+
+// Patients may only access their own records; doctors and caregivers may access
+// the records of patients whose care team they belong to
+func (c Caller) CanAccessPatient(userID int) (bool, error) {
+	if c.Can(PermReadOwnRecords) && c.ID == userID {
+		return true, nil
+	}
+	if !c.Can(PermReadPatientRecords) {
+		return false, nil
+	}
+	return c.InCareTeam(userID)
+}
+
+// Resolve which patient a request is about. Patients always act on themselves; clinicians
+// must name the patient. Writes 403/400 and returns false when the request isn't allowed.
+func PatientID(w http.ResponseWriter, r *http.Request, requestedID int) (int, bool) {
+	caller, ok := CallerFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Missing access token", http.StatusUnauthorized)
+		return 0, false
+	}
+
+	if caller.Can(PermReadOwnRecords) && requestedID == 0 {
+		return caller.ID, true
+	}
+	if requestedID <= 0 {
+		http.Error(w, "Invalid or missing user_id", http.StatusBadRequest)
+		return 0, false
+	}
+	allowed, err := caller.CanAccessPatient(requestedID)
+	if err != nil {
+		log.Println("Care team lookup error:", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return 0, false
+	}
+	if !allowed {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return 0, false
+	}
+	return requestedID, true
+}
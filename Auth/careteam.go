@@ -0,0 +1,57 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+var careTeamClient = &http.Client{Timeout: 5 * time.Second}
+
+// Call a Doctor service care-team endpoint with the caller's own token
+func (c Caller) careTeamRequest(path string, out interface{}) error {
+	if c.careTeamURL == "" {
+		return errors.New("care team lookup is not configured")
+	}
+
+	req, err := http.NewRequest(http.MethodGet, c.careTeamURL+path, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.token)
+
+	resp, err := careTeamClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("care team lookup failed with status %d", resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// Check whether the caller is assigned to the patient's care team
+func (c Caller) InCareTeam(userID int) (bool, error) {
+	var result struct {
+		Assigned bool `json:"assigned"`
+	}
+	if err := c.careTeamRequest(fmt.Sprintf("/api/checkCareTeam?user_id=%d", userID), &result); err != nil {
+		return false, err
+	}
+	return result.Assigned, nil
+}
+
+// IDs of the patients whose care team the caller belongs to
+func (c Caller) AssignedPatients() ([]int, error) {
+	var result struct {
+		UserIDs []int `json:"user_ids"`
+	}
+	if err := c.careTeamRequest("/api/getAssignedPatients", &result); err != nil {
+		return nil, err
+	}
+	return result.UserIDs, nil
+}
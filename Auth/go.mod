@@ -0,0 +1,5 @@
+module auth
+
+go 1.23.2
+
+require github.com/golang-jwt/jwt/v5 v5.2.1
@@ -0,0 +1,206 @@
+package auth
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Login lockout policy. Failures older than failureWindow are forgotten, and each lockout
+// doubles the last one up to maxLockout until the subject has gone lockoutMemory without one.
+const (
+	AccountFailureLimit = 5
+	IPFailureLimit      = 20
+	failureWindow       = 15 * time.Minute
+	baseLockout         = time.Minute
+	maxLockout          = time.Hour
+	lockoutMemory       = 24 * time.Hour
+)
+
+// Kinds of LoginLockouts rows
+const (
+	lockAccount = "account"
+	lockIP      = "ip"
+)
+
+// LoginGuard tracks failed logins per account and per client IP address in the service's
+// LoginLockouts table, and records lockouts in its AuditLog table
+type LoginGuard struct {
+	DB *sql.DB
+}
+
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+// Accounts are tracked by email whether or not they exist, so lockouts don't reveal which emails are registered.
+// Other records keyed by the email, such as audit subjects, use the same form.
+func NormalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// Client IP address of a request. X-Real-IP is only trusted when the request comes
+// through a local reverse proxy such as nginx.
+func ClientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if ip := net.ParseIP(host); ip != nil && (ip.IsLoopback() || ip.IsPrivate()) {
+		if realIP := strings.TrimSpace(r.Header.Get("X-Real-IP")); net.ParseIP(realIP) != nil {
+			return realIP
+		}
+	}
+	return host
+}
+
+// Length of the nth consecutive lockout
+func lockoutDuration(lockCount int) time.Duration {
+	d := baseLockout
+	for i := 1; i < lockCount && d < maxLockout; i++ {
+		d *= 2
+	}
+	if d > maxLockout {
+		d = maxLockout
+	}
+	return d
+}
+
+// Write an entry to the service's audit log. actorID is 0 for events not caused by a signed-in user.
+func WriteAudit(db execer, event, subject, ip string, actorID int, details string) error {
+	var actor sql.NullInt64
+	if actorID > 0 {
+		actor = sql.NullInt64{Int64: int64(actorID), Valid: true}
+	}
+	query := "INSERT INTO AuditLog (Event, Subject, IPAddress, ActorID, Details) VALUES (?, ?, ?, ?, ?)"
+	_, err := db.Exec(query, event, subject, ip, actor, details)
+	return err
+}
+
+// Remaining lockout for an account or IP address, zero when a login may be attempted
+func (g *LoginGuard) Locked(email, ip string) (time.Duration, error) {
+	var lockedUntil sql.NullTime
+	query := `SELECT MAX(LockedUntil) FROM LoginLockouts
+	          WHERE (Kind = ? AND Subject = ?) OR (Kind = ? AND Subject = ?)`
+	if err := g.DB.QueryRow(query, lockAccount, NormalizeEmail(email), lockIP, ip).Scan(&lockedUntil); err != nil {
+		return 0, err
+	}
+	if !lockedUntil.Valid {
+		return 0, nil
+	}
+	if remaining := time.Until(lockedUntil.Time); remaining > 0 {
+		return remaining, nil
+	}
+	return 0, nil
+}
+
+// Count a failed login against both the account and the IP address
+func (g *LoginGuard) RecordFailure(email, ip string) error {
+	if err := g.recordFailure(lockAccount, NormalizeEmail(email), AccountFailureLimit, ip); err != nil {
+		return err
+	}
+	return g.recordFailure(lockIP, ip, IPFailureLimit, ip)
+}
+
+func (g *LoginGuard) recordFailure(kind, subject string, limit int, ip string) error {
+	tx, err := g.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("INSERT IGNORE INTO LoginLockouts (Kind, Subject) VALUES (?, ?)", kind, subject); err != nil {
+		return err
+	}
+
+	var failedCount, lockCount int
+	var lastFailureAt, lockedUntil sql.NullTime
+	query := "SELECT FailedCount, LockCount, LastFailureAt, LockedUntil FROM LoginLockouts WHERE Kind = ? AND Subject = ? FOR UPDATE"
+	if err := tx.QueryRow(query, kind, subject).Scan(&failedCount, &lockCount, &lastFailureAt, &lockedUntil); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	if !lastFailureAt.Valid || now.Sub(lastFailureAt.Time) > failureWindow {
+		failedCount = 0
+	}
+	if !lockedUntil.Valid || now.Sub(lockedUntil.Time) > lockoutMemory {
+		lockCount = 0
+	}
+	failedCount++
+
+	var lockout time.Duration
+	if failedCount >= limit {
+		lockCount++
+		lockout = lockoutDuration(lockCount)
+		lockedUntil = sql.NullTime{Time: now.Add(lockout), Valid: true}
+		failedCount = 0
+	}
+
+	update := "UPDATE LoginLockouts SET FailedCount = ?, LockCount = ?, LastFailureAt = ?, LockedUntil = ? WHERE Kind = ? AND Subject = ?"
+	if _, err := tx.Exec(update, failedCount, lockCount, now, lockedUntil, kind, subject); err != nil {
+		return err
+	}
+
+	if lockout > 0 {
+		details := fmt.Sprintf("Locked for %s after %d failed logins", lockout, limit)
+		if err := WriteAudit(tx, kind+"_locked", subject, ip, 0, details); err != nil {
+			return err
+		}
+		log.Printf("Login lockout: %s %s %s\n", kind, subject, details)
+	}
+	return tx.Commit()
+}
+
+// Clear an account's failure count after a successful login. IP address counts are left
+// to expire so one valid login can't reset an attacker's budget.
+func (g *LoginGuard) RecordSuccess(email string) error {
+	_, err := g.DB.Exec("DELETE FROM LoginLockouts WHERE Kind = ? AND Subject = ?", lockAccount, NormalizeEmail(email))
+	return err
+}
+
+// Lift the lockout on an account and/or IP address. Reports whether anything was cleared.
+func (g *LoginGuard) Unlock(email, ip string, actorID int, actorIP string) (bool, error) {
+	subjects := map[string]string{}
+	if email != "" {
+		subjects[lockAccount] = NormalizeEmail(email)
+	}
+	if ip != "" {
+		subjects[lockIP] = ip
+	}
+
+	tx, err := g.DB.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	cleared := false
+	for kind, subject := range subjects {
+		result, err := tx.Exec("DELETE FROM LoginLockouts WHERE Kind = ? AND Subject = ?", kind, subject)
+		if err != nil {
+			return false, err
+		}
+		if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+			continue
+		}
+		cleared = true
+		if err := WriteAudit(tx, kind+"_unlocked", subject, actorIP, actorID, "Unlocked by admin"); err != nil {
+			return false, err
+		}
+	}
+	return cleared, tx.Commit()
+}
+
+// Reject a login attempt while the account or IP address is locked out. The message is the
+// same for every email so lockouts don't reveal which accounts exist.
+func WriteLockedOut(w http.ResponseWriter, remaining time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(remaining.Seconds()))))
+	http.Error(w, "Too many failed login attempts. Please try again later.", http.StatusTooManyRequests)
+}
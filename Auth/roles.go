@@ -0,0 +1,105 @@
+package auth
+
+import "net/http"
+
+// Caller roles
+const (
+	RolePatient   = "patient"
+	RoleDoctor    = "doctor"
+	RoleCaregiver = "caregiver"
+	RoleAdmin     = "admin"
+	RoleService   = "service" // Another service calling on its own behalf
+)
+
+// Expected issuer for each role, so a token can't claim a role its issuer doesn't hand out
+var roleIssuers = map[string]string{
+	RolePatient:   IssuerUserService,
+	RoleDoctor:    IssuerDoctorService,
+	RoleCaregiver: IssuerDoctorService,
+	RoleAdmin:     IssuerDoctorService,
+	RoleService:   IssuerInternal,
+}
+
+// Permission names an action guarded by a route
+type Permission string
+
+const (
+	PermReadOwnRecords     Permission = "records:read:own"
+	PermReadPatientRecords Permission = "records:read:any"
+	PermEditOwnProfile     Permission = "profile:edit:own"
+	PermManageOwnData      Permission = "data:manage:own"
+	PermEditStaffProfile   Permission = "staff_profile:edit:own"
+	PermSubmitAssessment   Permission = "assessment:submit"
+	PermViewAlerts         Permission = "alerts:read"
+	PermResolveAlerts      Permission = "alerts:resolve"
+	PermManageDoctors      Permission = "doctors:manage"
+	PermManageCareTeams    Permission = "care_teams:manage"
+	PermUnlockAccounts     Permission = "accounts:unlock"
+	PermManageRiskModels   Permission = "risk_models:manage"
+	PermManageQuestions    Permission = "questions:manage"
+	PermPostNotifications  Permission = "notifications:post"  // Create patient notifications and doctor alerts
+	PermSendAccountEmails  Permission = "account_emails:send" // Password reset and email verification links
+	PermScoreInBatch       Permission = "risk:score_batch"    // Re-score stored assessments in bulk
+	PermExploreRisk        Permission = "risk:what_if"        // Compare a patient's score with changed answers
+)
+
+// Permissions granted to each role. Record access for doctors and caregivers is further
+// limited to patients on their care team. Admins manage accounts but don't read clinical data.
+var rolePermissions = map[string]map[Permission]bool{
+	RolePatient: {
+		PermReadOwnRecords:   true,
+		PermEditOwnProfile:   true,
+		PermManageOwnData:    true,
+		PermSubmitAssessment: true,
+	},
+	RoleDoctor: {
+		PermReadPatientRecords: true,
+		PermEditStaffProfile:   true,
+		PermViewAlerts:         true,
+		PermResolveAlerts:      true,
+		PermExploreRisk:        true,
+	},
+	RoleCaregiver: {
+		PermReadPatientRecords: true,
+		PermEditStaffProfile:   true,
+		PermViewAlerts:         true,
+		PermExploreRisk:        true,
+	},
+	RoleAdmin: {
+		PermEditStaffProfile: true,
+		PermManageDoctors:    true,
+		PermManageCareTeams:  true,
+		PermUnlockAccounts:   true,
+		PermManageRiskModels: true,
+		PermManageQuestions:  true,
+		PermScoreInBatch:     true,
+	},
+	RoleService: {
+		PermPostNotifications: true,
+		PermSendAccountEmails: true,
+		PermScoreInBatch:      true,
+		PermExploreRisk:       true,
+	},
+}
+
+// Check whether the caller's role grants a permission
+func (c Caller) Can(p Permission) bool {
+	return rolePermissions[c.Role][p]
+}
+
+// Middleware that verifies the access token and rejects callers whose role lacks the permission
+func (v *Verifier) RequirePermission(p Permission, next http.HandlerFunc) http.HandlerFunc {
+	return v.Require(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodOptions {
+			next(w, r)
+			return
+		}
+
+		caller, _ := CallerFromContext(r.Context())
+		if !caller.Can(p) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		next(w, r)
+	})
+}
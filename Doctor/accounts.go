@@ -0,0 +1,442 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+
+	"auth"
+
+	"github.com/gorilla/mux"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	emailRegex = regexp.MustCompile(`^[a-zA-Z0-9._%+-]+@[a-zA-Z0-9.-]+\.[a-zA-Z]{2,}$`)
+	phoneRegex = regexp.MustCompile(`^\+?[0-9]{7,15}$`) // Allows optional '+' and 7-15 digits
+)
+
+// bcrypt hash of a random string, compared against when a login names an unknown email
+const dummyPasswordHash = "$2a$10$2.qeZs.T3M8dz00S8JfQP.NtQmwqbZk9GICk1CJAJhWQstz.ZfREu"
+
+// Validate doctor account fields. Password is only checked when the account is being created.
+func validateDoctorInput(d Doctor, requirePassword bool) map[string]string {
+	errors := make(map[string]string)
+
+	if d.Name == "" {
+		errors["name"] = "Name is required"
+	}
+	if d.Email == "" {
+		errors["email"] = "Email is required"
+	} else if !emailRegex.MatchString(d.Email) {
+		errors["email"] = "Invalid email format"
+	}
+	if requirePassword && len(d.Password) < 8 {
+		errors["password"] = "Password must be at least 8 characters long"
+	}
+	if d.Role != auth.RoleDoctor && d.Role != auth.RoleCaregiver && d.Role != auth.RoleAdmin {
+		errors["role"] = "Role must be doctor, caregiver or admin"
+	}
+	if d.PhoneNumber != "" && !phoneRegex.MatchString(d.PhoneNumber) {
+		errors["phone"] = "Invalid phone number format"
+	}
+	if len(d.Specialty) > 100 {
+		errors["specialty"] = "Specialty must be at most 100 characters"
+	}
+	if len(d.Clinic) > 255 {
+		errors["clinic"] = "Clinic must be at most 255 characters"
+	}
+
+	return errors
+}
+
+// Check whether an email is already used by a different doctor account
+func emailTaken(db *sql.DB, email string, doctorID int) (bool, error) {
+	var existingID int
+	err := db.QueryRow("SELECT DoctorID FROM Doctors WHERE Email = ?", email).Scan(&existingID)
+	if err == sql.ErrNoRows {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	return existingID != doctorID, nil
+}
+
+// Write validation errors as a JSON object keyed by field
+func writeValidationErrors(w http.ResponseWriter, validationErrors map[string]string) {
+	log.Println("Validation errors:", validationErrors)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+	json.NewEncoder(w).Encode(validationErrors)
+}
+
+// Parse the doctor_id path variable
+func doctorIDFromPath(w http.ResponseWriter, r *http.Request) (int, bool) {
+	doctorID, err := strconv.Atoi(mux.Vars(r)["doctor_id"])
+	if err != nil || doctorID <= 0 {
+		http.Error(w, `{"message":"Invalid Doctor ID"}`, http.StatusBadRequest)
+		return 0, false
+	}
+	return doctorID, true
+}
+
+// Create the first admin account from ADMIN_EMAIL and ADMIN_PASSWORD (and optionally
+// ADMIN_NAME) when the database has no admin yet. Once an admin exists the variables are
+// ignored, and further accounts are created through /api/createDoctor.
+func bootstrapAdmin(db *sql.DB) error {
+	var admins int
+	if err := db.QueryRow("SELECT COUNT(*) FROM Doctors WHERE Role = ?", auth.RoleAdmin).Scan(&admins); err != nil {
+		return err
+	}
+	if admins > 0 {
+		return nil
+	}
+
+	admin := Doctor{Name: os.Getenv("ADMIN_NAME"), Email: os.Getenv("ADMIN_EMAIL"), Password: os.Getenv("ADMIN_PASSWORD"), Role: auth.RoleAdmin}
+	if admin.Email == "" || admin.Password == "" {
+		log.Println("No admin account exists; set ADMIN_EMAIL and ADMIN_PASSWORD to create one at startup")
+		return nil
+	}
+	if admin.Name == "" {
+		admin.Name = "Clinic Admin"
+	}
+	if validationErrors := validateDoctorInput(admin, true); len(validationErrors) > 0 {
+		return fmt.Errorf("invalid admin account settings: %v", validationErrors)
+	}
+	taken, err := emailTaken(db, admin.Email, 0)
+	if err != nil {
+		return err
+	}
+	if taken {
+		return fmt.Errorf("ADMIN_EMAIL %s already belongs to a non-admin account", admin.Email)
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(admin.Password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+	query := "INSERT INTO Doctors (Name, Email, PasswordHash, Role) VALUES (?, ?, ?, ?)"
+	if _, err := db.Exec(query, admin.Name, admin.Email, hashedPassword, admin.Role); err != nil {
+		return err
+	}
+	log.Printf("Created admin account %s from ADMIN_EMAIL; unset ADMIN_PASSWORD now that it exists\n", admin.Email)
+	return nil
+}
+
+// Create a doctor, caregiver or admin account. Admin only.
+func createDoctorHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
+	var d Doctor
+	if err := json.NewDecoder(r.Body).Decode(&d); err != nil {
+		log.Println("Invalid JSON request:", err)
+		http.Error(w, `{"message":"Invalid JSON request"}`, http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	if d.Role == "" {
+		d.Role = auth.RoleDoctor
+	}
+	validationErrors := validateDoctorInput(d, true)
+
+	// Check if email is already registered
+	taken, err := emailTaken(db, d.Email, 0)
+	if err != nil {
+		log.Println("Database query error:", err)
+		http.Error(w, `{"message":"Internal server error"}`, http.StatusInternalServerError)
+		return
+	}
+	if taken {
+		validationErrors["email"] = "Email address already in use"
+	}
+
+	if len(validationErrors) > 0 {
+		writeValidationErrors(w, validationErrors)
+		return
+	}
+
+	// Hash the password before storing it
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(d.Password), bcrypt.DefaultCost)
+	if err != nil {
+		log.Println("Password hashing error:", err)
+		http.Error(w, `{"message":"Internal server error"}`, http.StatusInternalServerError)
+		return
+	}
+
+	query := "INSERT INTO Doctors (Name, Email, PasswordHash, Role, Specialty, Clinic, PhoneNumber) VALUES (?, ?, ?, ?, ?, ?, ?)"
+	result, err := db.Exec(query, d.Name, d.Email, hashedPassword, d.Role, d.Specialty, d.Clinic, d.PhoneNumber)
+	if err != nil {
+		log.Println("Database insert error:", err)
+		http.Error(w, `{"message":"Internal server error"}`, http.StatusInternalServerError)
+		return
+	}
+	doctorID, _ := result.LastInsertId()
+
+	caller, _ := auth.CallerFromContext(r.Context())
+	log.Printf("Admin %d created %s account %d\n", caller.ID, d.Role, doctorID)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message":   "Doctor account created",
+		"doctor_id": doctorID,
+	})
+}
+
+// List doctor accounts. Deactivated accounts are only included with ?includeInactive=true. Admin only.
+func listDoctorsHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
+	query := "SELECT DoctorID, Name, Email, Role, Specialty, Clinic, PhoneNumber, IsActive FROM Doctors"
+	if r.URL.Query().Get("includeInactive") != "true" {
+		query += " WHERE IsActive = TRUE"
+	}
+	query += " ORDER BY Name"
+
+	rows, err := db.Query(query)
+	if err != nil {
+		log.Println("Database query error:", err)
+		http.Error(w, "Failed to fetch doctors", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	doctors := []Doctor{}
+	for rows.Next() {
+		var d Doctor
+		if err := rows.Scan(&d.DoctorID, &d.Name, &d.Email, &d.Role, &d.Specialty, &d.Clinic, &d.PhoneNumber, &d.Active); err != nil {
+			log.Println("Error scanning row:", err)
+			http.Error(w, "Failed to process data", http.StatusInternalServerError)
+			return
+		}
+		doctors = append(doctors, d)
+	}
+	if err := rows.Err(); err != nil {
+		log.Println("Error iterating rows:", err)
+		http.Error(w, "Failed to process data", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(doctors)
+}
+
+// Update any doctor's account details, including role. Passwords are not changed here. Admin only.
+func updateDoctorHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
+	doctorID, ok := doctorIDFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	var d Doctor
+	if err := json.NewDecoder(r.Body).Decode(&d); err != nil {
+		log.Println("Invalid JSON request:", err)
+		http.Error(w, `{"message":"Invalid JSON request"}`, http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	// Admins can't demote themselves and lock everyone out of account management
+	caller, _ := auth.CallerFromContext(r.Context())
+	if doctorID == caller.ID && d.Role != auth.RoleAdmin {
+		http.Error(w, `{"message":"You cannot change your own role"}`, http.StatusBadRequest)
+		return
+	}
+
+	saveDoctorDetails(w, db, doctorID, d)
+}
+
+// Update the authenticated doctor's own profile. Role can't be changed here.
+func updateDoctorDetailsHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
+	var d Doctor
+	if err := json.NewDecoder(r.Body).Decode(&d); err != nil {
+		log.Println("Invalid JSON request:", err)
+		http.Error(w, `{"message":"Invalid JSON request"}`, http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	caller, _ := auth.CallerFromContext(r.Context())
+	d.Role = caller.Role
+
+	saveDoctorDetails(w, db, caller.ID, d)
+}
+
+// Validate and store updated account details for a doctor
+func saveDoctorDetails(w http.ResponseWriter, db *sql.DB, doctorID int, d Doctor) {
+	validationErrors := validateDoctorInput(d, false)
+
+	taken, err := emailTaken(db, d.Email, doctorID)
+	if err != nil {
+		log.Println("Database query error while checking email:", err)
+		http.Error(w, `{"message":"Internal server error"}`, http.StatusInternalServerError)
+		return
+	}
+	if taken {
+		validationErrors["email"] = "Email address already in use"
+	}
+
+	if len(validationErrors) > 0 {
+		writeValidationErrors(w, validationErrors)
+		return
+	}
+
+	query := "UPDATE Doctors SET Name = ?, Email = ?, Role = ?, Specialty = ?, Clinic = ?, PhoneNumber = ? WHERE DoctorID = ?"
+	result, err := db.Exec(query, d.Name, d.Email, d.Role, d.Specialty, d.Clinic, d.PhoneNumber, doctorID)
+	if err != nil {
+		log.Println("Database update error:", err)
+		http.Error(w, `{"message":"Internal server error"}`, http.StatusInternalServerError)
+		return
+	}
+
+	// Check the doctor exists (MySQL reports 0 rows affected for unchanged rows too)
+	if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+		var exists int
+		if err := db.QueryRow("SELECT COUNT(*) FROM Doctors WHERE DoctorID = ?", doctorID).Scan(&exists); err != nil || exists == 0 {
+			http.Error(w, `{"message":"Doctor not found"}`, http.StatusNotFound)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Doctor details updated successfully"})
+}
+
+// Check whether a doctor account exists and is active
+func doctorActive(db *sql.DB, doctorID int) (bool, error) {
+	var active bool
+	err := db.QueryRow("SELECT IsActive FROM Doctors WHERE DoctorID = ?", doctorID).Scan(&active)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	return active, err
+}
+
+// Deactivate or reactivate a doctor account. Deactivated doctors can't log in, and the
+// tokens they already hold are rejected by every service. Admin only.
+func setDoctorActiveHandler(w http.ResponseWriter, r *http.Request, db *sql.DB, active bool) {
+	doctorID, ok := doctorIDFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	caller, _ := auth.CallerFromContext(r.Context())
+	if doctorID == caller.ID && !active {
+		http.Error(w, `{"message":"You cannot deactivate your own account"}`, http.StatusBadRequest)
+		return
+	}
+
+	result, err := db.Exec("UPDATE Doctors SET IsActive = ? WHERE DoctorID = ?", active, doctorID)
+	if err != nil {
+		log.Println("Database update error:", err)
+		http.Error(w, `{"message":"Internal server error"}`, http.StatusInternalServerError)
+		return
+	}
+	if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+		var exists int
+		if err := db.QueryRow("SELECT COUNT(*) FROM Doctors WHERE DoctorID = ?", doctorID).Scan(&exists); err != nil || exists == 0 {
+			http.Error(w, `{"message":"Doctor not found"}`, http.StatusNotFound)
+			return
+		}
+	}
+
+	message := "Doctor account deactivated"
+	if active {
+		message = "Doctor account reactivated"
+	}
+	log.Printf("Admin %d: %s (%d)\n", caller.ID, message, doctorID)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": message})
+}
+
+// Change the authenticated doctor's password. The current password must be supplied.
+func changePasswordHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
+	var request struct {
+		CurrentPassword string `json:"current_password"`
+		NewPassword     string `json:"new_password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		log.Println("Invalid JSON request:", err)
+		http.Error(w, `{"message":"Invalid JSON request"}`, http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	if len(request.NewPassword) < 8 {
+		writeValidationErrors(w, map[string]string{"new_password": "Password must be at least 8 characters long"})
+		return
+	}
+
+	caller, _ := auth.CallerFromContext(r.Context())
+
+	var storedHash string
+	err := db.QueryRow("SELECT PasswordHash FROM Doctors WHERE DoctorID = ?", caller.ID).Scan(&storedHash)
+	if err == sql.ErrNoRows {
+		http.Error(w, `{"message":"Doctor not found"}`, http.StatusNotFound)
+		return
+	} else if err != nil {
+		log.Println("Database query error:", err)
+		http.Error(w, `{"message":"Internal server error"}`, http.StatusInternalServerError)
+		return
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(storedHash), []byte(request.CurrentPassword)); err != nil {
+		http.Error(w, `{"message":"Current password is incorrect"}`, http.StatusUnauthorized)
+		return
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(request.NewPassword), bcrypt.DefaultCost)
+	if err != nil {
+		log.Println("Password hashing error:", err)
+		http.Error(w, `{"message":"Internal server error"}`, http.StatusInternalServerError)
+		return
+	}
+
+	if _, err := db.Exec("UPDATE Doctors SET PasswordHash = ? WHERE DoctorID = ?", hashedPassword, caller.ID); err != nil {
+		log.Println("Database update error:", err)
+		http.Error(w, `{"message":"Internal server error"}`, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Password changed successfully"})
+}
+
+// Lift a login lockout on a doctor account and/or client IP address. Admin only.
+func unlockAccountHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
+	var request struct {
+		Email     string `json:"email"`
+		IPAddress string `json:"ip_address"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		log.Println("Invalid JSON request:", err)
+		http.Error(w, "Invalid JSON request", http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	if request.Email == "" && request.IPAddress == "" {
+		http.Error(w, "Email or ip_address is required", http.StatusBadRequest)
+		return
+	}
+
+	caller, _ := auth.CallerFromContext(r.Context())
+	guard := auth.LoginGuard{DB: db}
+	cleared, err := guard.Unlock(request.Email, request.IPAddress, caller.ID, auth.ClientIP(r))
+	if err != nil {
+		log.Println("Unlock error:", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if !cleared {
+		http.Error(w, "No lockout found", http.StatusNotFound)
+		return
+	}
+
+	log.Printf("Admin %d unlocked email=%q ip=%q\n", caller.ID, request.Email, request.IPAddress)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Lockout cleared"})
+}
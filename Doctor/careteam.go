@@ -0,0 +1,233 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+
+	"auth"
+
+	"github.com/gorilla/mux"
+)
+
+// Check whether an active doctor or caregiver is assigned to a patient
+func isCareTeamMember(db *sql.DB, userID, doctorID int) (bool, error) {
+	query := `SELECT COUNT(*) FROM CareTeams c
+              JOIN Doctors d ON d.DoctorID = c.DoctorID
+              WHERE c.UserID = ? AND c.DoctorID = ? AND d.IsActive = TRUE`
+	var count int
+	err := db.QueryRow(query, userID, doctorID).Scan(&count)
+	return count > 0, err
+}
+
+// List the care team of a patient. Patients see their own team, admins any team,
+// and doctors/caregivers the teams they belong to.
+func getCareTeamHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
+	var request struct {
+		UserID int `json:"user_id"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			log.Println("Invalid JSON request:", err)
+			http.Error(w, "Invalid JSON request", http.StatusBadRequest)
+			return
+		}
+		defer r.Body.Close()
+	}
+
+	caller, _ := auth.CallerFromContext(r.Context())
+	switch {
+	case caller.IsPatient():
+		if request.UserID != 0 && request.UserID != caller.ID {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		request.UserID = caller.ID
+	case request.UserID <= 0:
+		http.Error(w, "Invalid or missing user_id", http.StatusBadRequest)
+		return
+	case !caller.Can(auth.PermManageCareTeams):
+		member, err := isCareTeamMember(db, request.UserID, caller.ID)
+		if err != nil {
+			log.Println("Database query error:", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		if !member {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+	}
+
+	query := `SELECT d.DoctorID, d.Name, d.Email, d.Role, d.Specialty, d.Clinic, d.PhoneNumber, d.IsActive
+              FROM CareTeams c
+              JOIN Doctors d ON d.DoctorID = c.DoctorID
+              WHERE c.UserID = ? AND d.IsActive = TRUE
+              ORDER BY d.Role, d.Name`
+	rows, err := db.Query(query, request.UserID)
+	if err != nil {
+		log.Println("Database query error:", err)
+		http.Error(w, "Failed to fetch care team", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	members := []Doctor{}
+	for rows.Next() {
+		var d Doctor
+		if err := rows.Scan(&d.DoctorID, &d.Name, &d.Email, &d.Role, &d.Specialty, &d.Clinic, &d.PhoneNumber, &d.Active); err != nil {
+			log.Println("Error scanning row:", err)
+			http.Error(w, "Failed to process data", http.StatusInternalServerError)
+			return
+		}
+		members = append(members, d)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(members)
+}
+
+// Report whether the caller is on a patient's care team. Used by other services to scope record access.
+func checkCareTeamHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
+	userID, err := strconv.Atoi(r.URL.Query().Get("user_id"))
+	if err != nil || userID <= 0 {
+		http.Error(w, "Invalid or missing user_id", http.StatusBadRequest)
+		return
+	}
+
+	caller, _ := auth.CallerFromContext(r.Context())
+	assigned := false
+	if caller.IsDoctor() || caller.IsCaregiver() {
+		assigned, err = isCareTeamMember(db, userID, caller.ID)
+		if err != nil {
+			log.Println("Database query error:", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]bool{"assigned": assigned})
+}
+
+// List the IDs of the patients the calling doctor or caregiver is assigned to
+func getAssignedPatientsHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
+	caller, _ := auth.CallerFromContext(r.Context())
+	userIDs := []int{}
+
+	if caller.IsDoctor() || caller.IsCaregiver() {
+		query := `SELECT c.UserID FROM CareTeams c
+                  JOIN Doctors d ON d.DoctorID = c.DoctorID
+                  WHERE c.DoctorID = ? AND d.IsActive = TRUE
+                  ORDER BY c.UserID`
+		rows, err := db.Query(query, caller.ID)
+		if err != nil {
+			log.Println("Database query error:", err)
+			http.Error(w, "Failed to fetch assigned patients", http.StatusInternalServerError)
+			return
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var userID int
+			if err := rows.Scan(&userID); err != nil {
+				log.Println("Error scanning row:", err)
+				http.Error(w, "Failed to process data", http.StatusInternalServerError)
+				return
+			}
+			userIDs = append(userIDs, userID)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string][]int{"user_ids": userIDs})
+}
+
+// Assign a doctor or caregiver to a patient's care team. Admin only.
+func assignCareTeamMemberHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
+	var request struct {
+		UserID   int `json:"user_id"`
+		DoctorID int `json:"doctor_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		log.Println("Invalid JSON request:", err)
+		http.Error(w, "Invalid JSON request", http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	if request.UserID <= 0 || request.DoctorID <= 0 {
+		http.Error(w, "Invalid or missing user_id or doctor_id", http.StatusBadRequest)
+		return
+	}
+
+	// Only active doctors and caregivers can join a care team
+	var role string
+	var active bool
+	err := db.QueryRow("SELECT Role, IsActive FROM Doctors WHERE DoctorID = ?", request.DoctorID).Scan(&role, &active)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Doctor not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		log.Println("Database query error:", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if !active || (role != auth.RoleDoctor && role != auth.RoleCaregiver) {
+		http.Error(w, "Only active doctors and caregivers can be assigned", http.StatusBadRequest)
+		return
+	}
+
+	if _, err := db.Exec("INSERT IGNORE INTO CareTeams (UserID, DoctorID) VALUES (?, ?)", request.UserID, request.DoctorID); err != nil {
+		log.Println("Database insert error:", err)
+		http.Error(w, "Failed to assign care team member", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Care team member assigned successfully"})
+}
+
+// Remove a doctor or caregiver from a patient's care team. Admin only.
+func removeCareTeamMemberHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
+	vars := mux.Vars(r)
+	userID, err := strconv.Atoi(vars["user_id"])
+	if err != nil || userID <= 0 {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+	doctorID, ok := doctorIDFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	result, err := db.Exec("DELETE FROM CareTeams WHERE UserID = ? AND DoctorID = ?", userID, doctorID)
+	if err != nil {
+		log.Println("Database delete error:", err)
+		http.Error(w, "Failed to remove care team member", http.StatusInternalServerError)
+		return
+	}
+	if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+		http.Error(w, "No such care team assignment", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Care team member removed successfully"})
+}
+
+// Remove the authenticated patient from every care team. Called by the User service when an account is deleted.
+func deleteUserDataHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
+	caller, _ := auth.CallerFromContext(r.Context())
+
+	if _, err := db.Exec("DELETE FROM CareTeams WHERE UserID = ?", caller.ID); err != nil {
+		log.Println("Database delete error:", err)
+		http.Error(w, "Failed to delete data", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Care team data deleted"})
+}
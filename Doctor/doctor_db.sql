@@ -8,9 +8,79 @@ CREATE TABLE IF NOT EXISTS Doctors (
     DoctorID INT AUTO_INCREMENT PRIMARY KEY,
     Name VARCHAR(255) NOT NULL,
     Email VARCHAR(100) UNIQUE NOT NULL,
-    PasswordHash VARCHAR(255) NOT NULL -- Changed to store hashed passwords
+    PasswordHash VARCHAR(255) NOT NULL, -- Changed to store hashed passwords
+    Role ENUM('doctor', 'caregiver', 'admin') NOT NULL DEFAULT 'doctor',
+    Specialty VARCHAR(100) NOT NULL DEFAULT '',
+    Clinic VARCHAR(255) NOT NULL DEFAULT '',
+    PhoneNumber VARCHAR(15) NOT NULL DEFAULT '',
+    IsActive BOOLEAN NOT NULL DEFAULT TRUE,
+    MfaSecret VARCHAR(64) NULL, -- Base32 TOTP secret, set at enrolment
+    MfaEnabled BOOLEAN NOT NULL DEFAULT FALSE,
+    MfaLastUsedStep BIGINT NOT NULL DEFAULT 0, -- Last accepted TOTP time step, so codes can't be replayed
+    CreatedAt TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UpdatedAt TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP
 );
 
 -- Insert a test doctor with a hashed password
 INSERT INTO Doctors (Name, Email, PasswordHash) VALUES
 ('Dr. John Doe', 'johndoe7@gmail.com', '$2a$10$.Nl5tbg7enAt9PUEG.DBe.DHj0vRrldExrcaLN9e9I6dZ.bMPy/ha');
+
+-- No admin account is seeded; the Doctor service creates the first one from ADMIN_EMAIL and ADMIN_PASSWORD
+
+-- Care teams: which doctors and caregivers look after each patient (UserID from user_db)
+CREATE TABLE IF NOT EXISTS CareTeams (
+    UserID INT NOT NULL,
+    DoctorID INT NOT NULL,
+    AssignedAt TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (UserID, DoctorID),
+    FOREIGN KEY (DoctorID) REFERENCES Doctors(DoctorID) ON DELETE CASCADE
+);
+
+-- Assign the test doctor to the sample patients
+INSERT INTO CareTeams (UserID, DoctorID) VALUES
+(1, 1),
+(2, 1),
+(5, 1);
+
+-- Failed login tracking per account (email) and per client IP address
+CREATE TABLE IF NOT EXISTS LoginLockouts (
+    Kind ENUM('account', 'ip') NOT NULL,
+    Subject VARCHAR(100) NOT NULL,
+    FailedCount INT NOT NULL DEFAULT 0,
+    LockCount INT NOT NULL DEFAULT 0,
+    LastFailureAt TIMESTAMP NULL,
+    LockedUntil TIMESTAMP NULL,
+    PRIMARY KEY (Kind, Subject)
+);
+
+-- Security events such as lockouts and admin unlocks
+CREATE TABLE IF NOT EXISTS AuditLog (
+    AuditID INT AUTO_INCREMENT PRIMARY KEY,
+    Event VARCHAR(50) NOT NULL,
+    Subject VARCHAR(100) NOT NULL,
+    IPAddress VARCHAR(45) NOT NULL DEFAULT '',
+    ActorID INT NULL,
+    Details TEXT,
+    CreatedAt TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Single-use MFA recovery codes, stored as SHA-256 hashes
+CREATE TABLE IF NOT EXISTS MfaRecoveryCodes (
+    DoctorID INT NOT NULL,
+    CodeHash CHAR(64) NOT NULL,
+    UsedAt TIMESTAMP NULL,
+    PRIMARY KEY (DoctorID, CodeHash),
+    FOREIGN KEY (DoctorID) REFERENCES Doctors(DoctorID) ON DELETE CASCADE
+);
+
+-- Pending second login steps, issued once the password has been checked. Doctors and admins
+-- without MFA get an 'enroll' session instead, which only allows setting up TOTP.
+CREATE TABLE IF NOT EXISTS MfaChallenges (
+    TokenHash CHAR(64) PRIMARY KEY,
+    DoctorID INT NOT NULL,
+    Purpose ENUM('login', 'enroll') NOT NULL DEFAULT 'login',
+    ExpiresAt TIMESTAMP NOT NULL,
+    Attempts INT NOT NULL DEFAULT 0,
+    CreatedAt TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (DoctorID) REFERENCES Doctors(DoctorID) ON DELETE CASCADE
+);
@@ -1,14 +1,18 @@
 module user_service
 
-go 1.23.1
+go 1.23.2
 
-require github.com/go-sql-driver/mysql v1.8.1
+require (
+	auth v0.0.0
+	github.com/go-sql-driver/mysql v1.8.1
+	github.com/gorilla/mux v1.8.1
+	github.com/rs/cors v1.11.1
+	golang.org/x/crypto v0.32.0
+)
 
 require (
 	filippo.io/edwards25519 v1.1.0 // indirect
-	github.com/gorilla/mux v1.8.1 // indirect
-	github.com/gorilla/securecookie v1.1.2 // indirect
-	github.com/gorilla/sessions v1.4.0 // indirect
-	github.com/rs/cors v1.11.1 // indirect
-	golang.org/x/crypto v0.32.0 // indirect
+	github.com/golang-jwt/jwt/v5 v5.2.1 // indirect
 )
+
+replace auth => ../Auth
@@ -8,6 +8,9 @@ import (
 	"net/http"
 	"os"
 	"regexp"
+	"time"
+
+	"auth"
 
 	_ "github.com/go-sql-driver/mysql"
 	"github.com/gorilla/mux"
@@ -25,6 +28,12 @@ func connectDB(dbUser, dbPassword, dbHost, dbName string) (*sql.DB, error) {
 	return db, db.Ping()
 }
 
+// Doctor sessions have no refresh token, so access tokens last a working session
+const accessTokenTTL = 8 * time.Hour
+
+// Secret used to sign access tokens, loaded from JWT_SECRET in main
+var jwtSecret []byte
+
 func main() {
 	// Get database connection details from environment variables
 	dbUser, dbPassword, dbHost, dbName := os.Getenv("DB_USER"), os.Getenv("DB_PASSWORD"), os.Getenv("DB_HOST"), os.Getenv("DB_NAME")
@@ -32,6 +41,9 @@ func main() {
 		log.Fatal("Database credentials not fully set in environment variables")
 	}
 
+	// Get token signing secret from environment variables
+	jwtSecret = auth.SecretFromEnv()
+
 	// Get local port from environment variables
 	//localPort := os.Getenv("LOCAL_PORT")
 	localPort := "5004"
@@ -46,22 +58,111 @@ func main() {
 	}
 	defer db.Close()
 
+	// Create the first admin account from the environment on a fresh database
+	if err := bootstrapAdmin(db); err != nil {
+		log.Fatalf("Failed to create admin account: %v", err)
+	}
+
 	// Initialize the router
 	router := mux.NewRouter()
+	verifier := &auth.Verifier{
+		Secret: jwtSecret,
+		// Tokens of deactivated accounts are rejected straight away
+		AccountActive: func(accountID int) (bool, error) {
+			return doctorActive(db, accountID)
+		},
+	}
 
 	// API Routes
 	router.HandleFunc("/api/authenticate", func(w http.ResponseWriter, r *http.Request) {
 		authenticationHandler(w, r, db)
 	}).Methods("POST")
-	router.HandleFunc("/api/getDoctorDetails", func(w http.ResponseWriter, r *http.Request) {
-		getDoctorDetailsHandler(w, r, db)
+	router.HandleFunc("/api/authenticate/mfa", func(w http.ResponseWriter, r *http.Request) {
+		verifyMFALoginHandler(w, r, db)
 	}).Methods("POST")
+	router.HandleFunc("/api/authenticate/mfa/enroll", func(w http.ResponseWriter, r *http.Request) {
+		enrollMFALoginHandler(w, r, db)
+	}).Methods("POST")
+	router.HandleFunc("/api/authenticate/mfa/confirm", func(w http.ResponseWriter, r *http.Request) {
+		confirmMFALoginHandler(w, r, db)
+	}).Methods("POST")
+	// Lets other services reject tokens of deactivated accounts; the verifier answers 401 for those
+	router.HandleFunc("/api/accountStatus", verifier.Require(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]bool{"active": true})
+	})).Methods("GET")
+	router.HandleFunc("/api/getDoctorDetails", verifier.Require(func(w http.ResponseWriter, r *http.Request) {
+		getDoctorDetailsHandler(w, r, db)
+	})).Methods("POST")
+
+	router.HandleFunc("/api/updateDoctorDetails", verifier.RequirePermission(auth.PermEditStaffProfile, func(w http.ResponseWriter, r *http.Request) {
+		updateDoctorDetailsHandler(w, r, db)
+	})).Methods("PUT")
+	router.HandleFunc("/api/changePassword", verifier.RequirePermission(auth.PermEditStaffProfile, func(w http.ResponseWriter, r *http.Request) {
+		changePasswordHandler(w, r, db)
+	})).Methods("PUT")
+
+	// MFA enrolment
+	router.HandleFunc("/api/mfa/enroll", verifier.RequirePermission(auth.PermEditStaffProfile, func(w http.ResponseWriter, r *http.Request) {
+		enrollMFAHandler(w, r, db)
+	})).Methods("POST")
+	router.HandleFunc("/api/mfa/confirm", verifier.RequirePermission(auth.PermEditStaffProfile, func(w http.ResponseWriter, r *http.Request) {
+		confirmMFAHandler(w, r, db)
+	})).Methods("POST")
+	router.HandleFunc("/api/mfa/disable", verifier.RequirePermission(auth.PermEditStaffProfile, func(w http.ResponseWriter, r *http.Request) {
+		disableMFAHandler(w, r, db)
+	})).Methods("POST")
+
+	// Admin routes
+	router.HandleFunc("/api/createDoctor", verifier.RequirePermission(auth.PermManageDoctors, func(w http.ResponseWriter, r *http.Request) {
+		createDoctorHandler(w, r, db)
+	})).Methods("POST")
+	router.HandleFunc("/api/listDoctors", verifier.RequirePermission(auth.PermManageDoctors, func(w http.ResponseWriter, r *http.Request) {
+		listDoctorsHandler(w, r, db)
+	})).Methods("GET")
+	router.HandleFunc("/api/updateDoctor/{doctor_id}", verifier.RequirePermission(auth.PermManageDoctors, func(w http.ResponseWriter, r *http.Request) {
+		updateDoctorHandler(w, r, db)
+	})).Methods("PUT")
+	router.HandleFunc("/api/deactivateDoctor/{doctor_id}", verifier.RequirePermission(auth.PermManageDoctors, func(w http.ResponseWriter, r *http.Request) {
+		setDoctorActiveHandler(w, r, db, false)
+	})).Methods("PUT")
+	router.HandleFunc("/api/reactivateDoctor/{doctor_id}", verifier.RequirePermission(auth.PermManageDoctors, func(w http.ResponseWriter, r *http.Request) {
+		setDoctorActiveHandler(w, r, db, true)
+	})).Methods("PUT")
+	router.HandleFunc("/api/resetMfa/{doctor_id}", verifier.RequirePermission(auth.PermManageDoctors, func(w http.ResponseWriter, r *http.Request) {
+		resetMFAHandler(w, r, db)
+	})).Methods("PUT")
+	router.HandleFunc("/api/unlockAccount", verifier.RequirePermission(auth.PermUnlockAccounts, func(w http.ResponseWriter, r *http.Request) {
+		unlockAccountHandler(w, r, db)
+	})).Methods("POST")
+
+	// Care team routes
+	router.HandleFunc("/api/getCareTeam", verifier.Require(func(w http.ResponseWriter, r *http.Request) {
+		getCareTeamHandler(w, r, db)
+	})).Methods("POST")
+	router.HandleFunc("/api/checkCareTeam", verifier.Require(func(w http.ResponseWriter, r *http.Request) {
+		checkCareTeamHandler(w, r, db)
+	})).Methods("GET")
+	router.HandleFunc("/api/getAssignedPatients", verifier.Require(func(w http.ResponseWriter, r *http.Request) {
+		getAssignedPatientsHandler(w, r, db)
+	})).Methods("GET")
+	router.HandleFunc("/api/assignCareTeamMember", verifier.RequirePermission(auth.PermManageCareTeams, func(w http.ResponseWriter, r *http.Request) {
+		assignCareTeamMemberHandler(w, r, db)
+	})).Methods("POST")
+	router.HandleFunc("/api/removeCareTeamMember/{user_id}/{doctor_id}", verifier.RequirePermission(auth.PermManageCareTeams, func(w http.ResponseWriter, r *http.Request) {
+		removeCareTeamMemberHandler(w, r, db)
+	})).Methods("DELETE")
+
+	// Personal data requests, called by the User service with the patient's token
+	router.HandleFunc("/api/deleteUserData", verifier.RequirePermission(auth.PermManageOwnData, func(w http.ResponseWriter, r *http.Request) {
+		deleteUserDataHandler(w, r, db)
+	})).Methods("DELETE")
 
 	// CORS Configuration
 	c := cors.New(cors.Options{
 		AllowedOrigins:   []string{"*"},
-		AllowedMethods:   []string{"GET", "POST", "PUT"},
-		AllowedHeaders:   []string{"Content-Type"},
+		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE"},
+		AllowedHeaders:   []string{"Content-Type", "Authorization"},
 		AllowCredentials: true,
 	})
 	handler := c.Handler(router)
@@ -73,10 +174,16 @@ func main() {
 }
 
 type Doctor struct {
-	DoctorID int    `json:"id,omitempty"`
-	Name     string `json:"name,omitempty"`
-	Email    string `json:"email,omitempty"`
-	Password string `json:"password,omitempty"`
+	DoctorID    int    `json:"id,omitempty"`
+	Name        string `json:"name,omitempty"`
+	Email       string `json:"email,omitempty"`
+	Password    string `json:"password,omitempty"`
+	Role        string `json:"role,omitempty"`
+	Specialty   string `json:"specialty,omitempty"`
+	Clinic      string `json:"clinic,omitempty"`
+	PhoneNumber string `json:"phoneNumber,omitempty"`
+	Active      bool   `json:"active"`
+	MFAEnabled  bool   `json:"mfaEnabled,omitempty"`
 }
 
 func authenticationHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
@@ -100,59 +207,113 @@ func authenticationHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
 		return
 	}
 
+	// Refuse attempts while the account or client IP is locked out
+	guard := auth.LoginGuard{DB: db}
+	ip := auth.ClientIP(r)
+	remaining, err := guard.Locked(credentials.Email, ip)
+	if err != nil {
+		log.Println("Lockout check error:", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if remaining > 0 {
+		auth.WriteLockedOut(w, remaining)
+		return
+	}
+
 	// Query the database for the doctor with this email
 	var doctor Doctor
-	query := `SELECT DoctorID, PasswordHash FROM Doctors WHERE Email = ?`
-	err := db.QueryRow(query, credentials.Email).Scan(&doctor.DoctorID, &doctor.Password)
-	if err != nil {
-		if err == sql.ErrNoRows {
-			http.Error(w, "Invalid email or password", http.StatusUnauthorized)
-		} else {
-			log.Println("Database error:", err)
-			http.Error(w, "Internal server error", http.StatusInternalServerError)
-		}
+	query := `SELECT DoctorID, PasswordHash, Role, IsActive, MfaEnabled FROM Doctors WHERE Email = ?`
+	err = db.QueryRow(query, credentials.Email).Scan(&doctor.DoctorID, &doctor.Password, &doctor.Role, &doctor.Active, &doctor.MFAEnabled)
+	if err == sql.ErrNoRows {
+		// Unknown emails are checked against a dummy hash so they take as long as a wrong password
+		doctor.Password = dummyPasswordHash
+	} else if err != nil {
+		log.Println("Database error:", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
 		return
 	}
 
 	// Verify password with bcrypt
-	if err := bcrypt.CompareHashAndPassword([]byte(doctor.Password), []byte(credentials.Password)); err != nil {
+	if err := bcrypt.CompareHashAndPassword([]byte(doctor.Password), []byte(credentials.Password)); err != nil || doctor.DoctorID == 0 {
+		if err := guard.RecordFailure(credentials.Email, ip); err != nil {
+			log.Println("Error recording failed login:", err)
+		}
 		http.Error(w, "Invalid email or password", http.StatusUnauthorized)
 		return
 	}
 
-	// Authentication successful - Send doctor details (excluding password)
-	response := map[string]interface{}{
-		"message":   "Authentication successful",
-		"doctor_id": doctor.DoctorID,
+	// Deactivated accounts can no longer log in
+	if !doctor.Active {
+		http.Error(w, "Account has been deactivated", http.StatusForbidden)
+		return
+	}
+
+	// With MFA enabled the password only earns a second login step. Failed-login counts
+	// are cleared once that step succeeds, so codes can't be guessed between passwords.
+	if doctor.MFAEnabled {
+		writeMFAChallenge(w, db, doctor.DoctorID)
+		return
+	}
+
+	// Doctors and admins without MFA only get an enrolment session until they set it up
+	if mfaRequired(doctor.Role) {
+		writeMFAEnrollment(w, db, doctor.DoctorID)
+		return
+	}
+
+	if err := guard.RecordSuccess(credentials.Email); err != nil {
+		log.Println("Error clearing failed logins:", err)
+	}
+	writeLoginResponse(w, doctor)
+}
+
+// Issue a signed access token carrying the account's role (doctor, caregiver or admin)
+func writeLoginResponse(w http.ResponseWriter, doctor Doctor) {
+	response, err := loginResponse(doctor)
+	if err != nil {
+		log.Println("Access token signing error:", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
 	json.NewEncoder(w).Encode(response)
 }
 
-func getDoctorDetailsHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
-	// Parse request body
-	var request struct {
-		DoctorID int `json:"doctor_id"`
+// Successful login body with a new access token and the doctor's details (excluding password)
+func loginResponse(doctor Doctor) (map[string]interface{}, error) {
+	accessToken, err := auth.IssueToken(jwtSecret, doctor.DoctorID, doctor.Role, accessTokenTTL)
+	if err != nil {
+		return nil, err
 	}
 
-	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
-		log.Println("Invalid JSON request:", err)
-		http.Error(w, "Invalid JSON request", http.StatusBadRequest)
-		return
+	response := map[string]interface{}{
+		"message":      "Authentication successful",
+		"doctor_id":    doctor.DoctorID,
+		"role":         doctor.Role,
+		"access_token": accessToken,
+		"token_type":   "Bearer",
+		"expires_in":   int(accessTokenTTL.Seconds()),
+		"mfa_enabled":  doctor.MFAEnabled,
 	}
-	defer r.Body.Close()
+	return response, nil
+}
 
-	// Validate Doctor ID
-	if request.DoctorID <= 0 {
-		http.Error(w, "Invalid Doctor ID", http.StatusBadRequest)
+func getDoctorDetailsHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
+	// Doctor ID comes from the verified access token
+	caller, _ := auth.CallerFromContext(r.Context())
+	if caller.IsPatient() {
+		http.Error(w, "Forbidden", http.StatusForbidden)
 		return
 	}
 
 	// Query doctor details from the database
 	var doctor Doctor
-	query := "SELECT DoctorID, Name, Email FROM Doctors WHERE DoctorID = ?"
-	err := db.QueryRow(query, request.DoctorID).Scan(&doctor.DoctorID, &doctor.Name, &doctor.Email)
+	query := "SELECT DoctorID, Name, Email, Role, Specialty, Clinic, PhoneNumber, IsActive, MfaEnabled FROM Doctors WHERE DoctorID = ?"
+	err := db.QueryRow(query, caller.ID).Scan(&doctor.DoctorID, &doctor.Name, &doctor.Email, &doctor.Role,
+		&doctor.Specialty, &doctor.Clinic, &doctor.PhoneNumber, &doctor.Active, &doctor.MFAEnabled)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			http.Error(w, "Doctor not found", http.StatusNotFound)
@@ -0,0 +1,675 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"auth"
+)
+
+// TOTP parameters (RFC 6238 defaults, which authenticator apps expect)
+const (
+	totpPeriod = 30
+	totpDigits = 6
+	totpSkew   = 1 // accept codes one step either side of now to allow for clock drift
+	mfaIssuer  = "LionsBefrienders"
+)
+
+// Second login step limits
+const (
+	mfaChallengeTTL      = 5 * time.Minute
+	mfaEnrollmentTTL     = 10 * time.Minute // long enough to install an authenticator app
+	mfaChallengeAttempts = 5
+	recoveryCodeCount    = 10
+)
+
+var (
+	secretEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+	totpCodeRegex  = regexp.MustCompile(`^[0-9]{6}$`)
+)
+
+// Generate the RFC 6238 TOTP code (HMAC-SHA1) for a time step
+func totpCode(secret []byte, step int64, digits int) string {
+	msg := make([]byte, 8)
+	binary.BigEndian.PutUint64(msg, uint64(step))
+	mac := hmac.New(sha1.New, secret)
+	mac.Write(msg)
+	sum := mac.Sum(nil)
+
+	// Dynamic truncation (RFC 4226 section 5.3)
+	offset := sum[len(sum)-1] & 0x0f
+	code := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	mod := uint32(1)
+	for i := 0; i < digits; i++ {
+		mod *= 10
+	}
+	return fmt.Sprintf("%0*d", digits, code%mod)
+}
+
+// Time step containing t
+func totpStep(t time.Time) int64 {
+	return t.Unix() / totpPeriod
+}
+
+// Check a code against the steps around now and return the step it matched. Steps at or
+// before lastStep have already been used, so a code can't be replayed.
+func verifyTOTP(secret []byte, code string, now time.Time, lastStep int64) (int64, bool) {
+	current := totpStep(now)
+	for step := current - totpSkew; step <= current+totpSkew; step++ {
+		if step <= lastStep {
+			continue
+		}
+		if hmac.Equal([]byte(totpCode(secret, step, totpDigits)), []byte(code)) {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// otpauth:// URI for authenticator apps, usually shown as a QR code
+func provisioningURI(email, secret string) string {
+	params := url.Values{
+		"secret":    {secret},
+		"issuer":    {mfaIssuer},
+		"algorithm": {"SHA1"},
+		"digits":    {fmt.Sprint(totpDigits)},
+		"period":    {fmt.Sprint(totpPeriod)},
+	}
+	return "otpauth://totp/" + url.PathEscape(mfaIssuer+":"+email) + "?" + params.Encode()
+}
+
+// Generate a random token of n bytes, hex encoded
+func randomToken(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// Tokens and recovery codes are stored as SHA-256 hashes
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// Recovery codes are compared without case or the dash shown to the user
+func normalizeRecoveryCode(code string) string {
+	return strings.ToUpper(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+}
+
+// Generate a fresh set of single-use recovery codes formatted as XXXXX-XXXXX
+func newRecoveryCodes() ([]string, error) {
+	codes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		b := make([]byte, 7)
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+		code := secretEncoding.EncodeToString(b)[:10]
+		codes[i] = code[:5] + "-" + code[5:]
+	}
+	return codes, nil
+}
+
+// Replace a doctor's recovery codes
+func storeRecoveryCodes(tx *sql.Tx, doctorID int, codes []string) error {
+	if _, err := tx.Exec("DELETE FROM MfaRecoveryCodes WHERE DoctorID = ?", doctorID); err != nil {
+		return err
+	}
+	for _, code := range codes {
+		query := "INSERT INTO MfaRecoveryCodes (DoctorID, CodeHash) VALUES (?, ?)"
+		if _, err := tx.Exec(query, doctorID, hashToken(normalizeRecoveryCode(code))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Check a TOTP or recovery code for a doctor with MFA enabled. A matched TOTP step is
+// recorded and a matched recovery code is used up, both within tx.
+func checkSecondFactor(tx *sql.Tx, doctorID int, code string) (bool, error) {
+	var secret string
+	var lastStep int64
+	query := "SELECT MfaSecret, MfaLastUsedStep FROM Doctors WHERE DoctorID = ? AND MfaEnabled = TRUE FOR UPDATE"
+	if err := tx.QueryRow(query, doctorID).Scan(&secret, &lastStep); err != nil {
+		return false, err
+	}
+
+	code = strings.TrimSpace(code)
+	if totpCodeRegex.MatchString(code) {
+		key, err := secretEncoding.DecodeString(secret)
+		if err != nil {
+			return false, err
+		}
+		step, ok := verifyTOTP(key, code, time.Now(), lastStep)
+		if !ok {
+			return false, nil
+		}
+		_, err = tx.Exec("UPDATE Doctors SET MfaLastUsedStep = ? WHERE DoctorID = ?", step, doctorID)
+		return err == nil, err
+	}
+
+	update := "UPDATE MfaRecoveryCodes SET UsedAt = NOW() WHERE DoctorID = ? AND CodeHash = ? AND UsedAt IS NULL"
+	result, err := tx.Exec(update, doctorID, hashToken(normalizeRecoveryCode(code)))
+	if err != nil {
+		return false, err
+	}
+	rowsAffected, _ := result.RowsAffected()
+	return rowsAffected == 1, nil
+}
+
+// Start a second login step for a doctor with MFA enabled
+func writeMFAChallenge(w http.ResponseWriter, db *sql.DB, doctorID int) {
+	token, err := randomToken(32)
+	if err != nil {
+		log.Println("MFA token generation error:", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	query := "INSERT INTO MfaChallenges (TokenHash, DoctorID, ExpiresAt) VALUES (?, ?, ?)"
+	if _, err := db.Exec(query, hashToken(token), doctorID, time.Now().Add(mfaChallengeTTL)); err != nil {
+		log.Println("Database insert error:", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message":      "Authentication code required",
+		"mfa_required": true,
+		"mfa_token":    token,
+		"expires_in":   int(mfaChallengeTTL.Seconds()),
+	})
+}
+
+// Second login step: exchange the mfa_token from /api/authenticate and a TOTP or recovery code for an access token
+func verifyMFALoginHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
+	var request struct {
+		MFAToken string `json:"mfa_token"`
+		Code     string `json:"code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		log.Println("Invalid JSON request:", err)
+		http.Error(w, "Invalid JSON request", http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	if request.MFAToken == "" || request.Code == "" {
+		http.Error(w, "mfa_token and code are required", http.StatusBadRequest)
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		log.Println("Database transaction error:", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	var doctor Doctor
+	var expiresAt time.Time
+	var attempts int
+	query := `SELECT c.DoctorID, c.ExpiresAt, c.Attempts, d.Email, d.Role, d.IsActive
+	          FROM MfaChallenges c
+	          JOIN Doctors d ON d.DoctorID = c.DoctorID
+	          WHERE c.TokenHash = ? AND c.Purpose = 'login' FOR UPDATE`
+	err = tx.QueryRow(query, hashToken(request.MFAToken)).Scan(&doctor.DoctorID, &expiresAt, &attempts, &doctor.Email, &doctor.Role, &doctor.Active)
+	if err == sql.ErrNoRows || (err == nil && (time.Now().After(expiresAt) || attempts >= mfaChallengeAttempts || !doctor.Active)) {
+		http.Error(w, "Login session is invalid or has expired", http.StatusUnauthorized)
+		return
+	} else if err != nil {
+		log.Println("Database query error:", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	// Wrong codes count towards the same lockout as wrong passwords
+	guard := auth.LoginGuard{DB: db}
+	ip := auth.ClientIP(r)
+	remaining, err := guard.Locked(doctor.Email, ip)
+	if err != nil {
+		log.Println("Lockout check error:", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if remaining > 0 {
+		auth.WriteLockedOut(w, remaining)
+		return
+	}
+
+	ok, err := checkSecondFactor(tx, doctor.DoctorID, request.Code)
+	if err != nil {
+		log.Println("MFA verification error:", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		if _, err := tx.Exec("UPDATE MfaChallenges SET Attempts = Attempts + 1 WHERE TokenHash = ?", hashToken(request.MFAToken)); err != nil {
+			log.Println("Database update error:", err)
+		} else if err := tx.Commit(); err != nil {
+			log.Println("Database commit error:", err)
+		}
+		if err := guard.RecordFailure(doctor.Email, ip); err != nil {
+			log.Println("Error recording failed login:", err)
+		}
+		http.Error(w, "Invalid authentication code", http.StatusUnauthorized)
+		return
+	}
+
+	if _, err := tx.Exec("DELETE FROM MfaChallenges WHERE TokenHash = ?", hashToken(request.MFAToken)); err != nil {
+		log.Println("Database delete error:", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		log.Println("Database commit error:", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	if err := guard.RecordSuccess(doctor.Email); err != nil {
+		log.Println("Error clearing failed logins:", err)
+	}
+	writeLoginResponse(w, doctor)
+}
+
+// Doctors and admins must have MFA. Until they enrol, their password only earns an enrolment session.
+func mfaRequired(role string) bool {
+	return role == auth.RoleDoctor || role == auth.RoleAdmin
+}
+
+// Begin TOTP enrolment for the authenticated doctor. MFA is only switched on once a code
+// from the new secret has been confirmed.
+func enrollMFAHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
+	caller, _ := auth.CallerFromContext(r.Context())
+	writeEnrollmentSecret(w, db, caller.ID)
+}
+
+// Generate a new TOTP secret for a doctor and send it with its provisioning URI
+func writeEnrollmentSecret(w http.ResponseWriter, db *sql.DB, doctorID int) {
+	var email string
+	var enabled bool
+	err := db.QueryRow("SELECT Email, MfaEnabled FROM Doctors WHERE DoctorID = ?", doctorID).Scan(&email, &enabled)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Doctor not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		log.Println("Database query error:", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if enabled {
+		http.Error(w, "Multi-factor authentication is already enabled", http.StatusConflict)
+		return
+	}
+
+	key := make([]byte, 20)
+	if _, err := rand.Read(key); err != nil {
+		log.Println("MFA secret generation error:", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	secret := secretEncoding.EncodeToString(key)
+
+	if _, err := db.Exec("UPDATE Doctors SET MfaSecret = ?, MfaLastUsedStep = 0 WHERE DoctorID = ?", secret, doctorID); err != nil {
+		log.Println("Database update error:", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(map[string]string{
+		"secret":           secret,
+		"provisioning_uri": provisioningURI(email, secret),
+	})
+}
+
+// Confirm TOTP enrolment with a code from the authenticator app. Returns the recovery codes, which are only shown once.
+func confirmMFAHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
+	var request struct {
+		Code string `json:"code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		log.Println("Invalid JSON request:", err)
+		http.Error(w, "Invalid JSON request", http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	caller, _ := auth.CallerFromContext(r.Context())
+
+	codes, ok := enableMFA(w, db, caller.ID, request.Code)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message":        "Multi-factor authentication enabled. Store these recovery codes somewhere safe; each can be used once.",
+		"recovery_codes": codes,
+	})
+}
+
+// Check a code from the newly enrolled secret and switch MFA on. Returns the new recovery
+// codes, or writes the error response and returns false.
+func enableMFA(w http.ResponseWriter, db *sql.DB, doctorID int, code string) ([]string, bool) {
+	tx, err := db.Begin()
+	if err != nil {
+		log.Println("Database transaction error:", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return nil, false
+	}
+	defer tx.Rollback()
+
+	var secret sql.NullString
+	var enabled bool
+	err = tx.QueryRow("SELECT MfaSecret, MfaEnabled FROM Doctors WHERE DoctorID = ? FOR UPDATE", doctorID).Scan(&secret, &enabled)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Doctor not found", http.StatusNotFound)
+		return nil, false
+	} else if err != nil {
+		log.Println("Database query error:", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return nil, false
+	}
+	if enabled {
+		http.Error(w, "Multi-factor authentication is already enabled", http.StatusConflict)
+		return nil, false
+	}
+	if !secret.Valid {
+		http.Error(w, "Start enrolment first", http.StatusBadRequest)
+		return nil, false
+	}
+
+	key, err := secretEncoding.DecodeString(secret.String)
+	if err != nil {
+		log.Println("MFA secret decoding error:", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return nil, false
+	}
+	step, ok := verifyTOTP(key, strings.TrimSpace(code), time.Now(), 0)
+	if !ok {
+		http.Error(w, "Invalid authentication code", http.StatusBadRequest)
+		return nil, false
+	}
+
+	codes, err := newRecoveryCodes()
+	if err != nil {
+		log.Println("Recovery code generation error:", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return nil, false
+	}
+	if err := storeRecoveryCodes(tx, doctorID, codes); err != nil {
+		log.Println("Database insert error:", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return nil, false
+	}
+	if _, err := tx.Exec("UPDATE Doctors SET MfaEnabled = TRUE, MfaLastUsedStep = ? WHERE DoctorID = ?", step, doctorID); err != nil {
+		log.Println("Database update error:", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return nil, false
+	}
+	if err := tx.Commit(); err != nil {
+		log.Println("Database commit error:", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return nil, false
+	}
+	return codes, true
+}
+
+// Start an enrolment session for a doctor or admin who logged in without MFA set up. The
+// enrollment_token only works at /api/authenticate/mfa/enroll and /api/authenticate/mfa/confirm.
+func writeMFAEnrollment(w http.ResponseWriter, db *sql.DB, doctorID int) {
+	token, err := randomToken(32)
+	if err != nil {
+		log.Println("MFA token generation error:", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	query := "INSERT INTO MfaChallenges (TokenHash, DoctorID, Purpose, ExpiresAt) VALUES (?, ?, 'enroll', ?)"
+	if _, err := db.Exec(query, hashToken(token), doctorID, time.Now().Add(mfaEnrollmentTTL)); err != nil {
+		log.Println("Database insert error:", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message":                 "Multi-factor authentication must be set up before you can log in",
+		"mfa_enrollment_required": true,
+		"enrollment_token":        token,
+		"expires_in":              int(mfaEnrollmentTTL.Seconds()),
+	})
+}
+
+// Look up the doctor behind an enrollment_token, or write 401 and return false
+func enrollmentSession(w http.ResponseWriter, db *sql.DB, token string) (Doctor, bool) {
+	var doctor Doctor
+	var expiresAt time.Time
+	var attempts int
+	query := `SELECT c.DoctorID, c.ExpiresAt, c.Attempts, d.Email, d.Role, d.IsActive
+	          FROM MfaChallenges c
+	          JOIN Doctors d ON d.DoctorID = c.DoctorID
+	          WHERE c.TokenHash = ? AND c.Purpose = 'enroll'`
+	err := db.QueryRow(query, hashToken(token)).Scan(&doctor.DoctorID, &expiresAt, &attempts, &doctor.Email, &doctor.Role, &doctor.Active)
+	if err == sql.ErrNoRows || (err == nil && (time.Now().After(expiresAt) || attempts >= mfaChallengeAttempts || !doctor.Active)) {
+		http.Error(w, "Enrolment session is invalid or has expired", http.StatusUnauthorized)
+		return Doctor{}, false
+	} else if err != nil {
+		log.Println("Database query error:", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return Doctor{}, false
+	}
+	return doctor, true
+}
+
+// First enrolment step at login: exchange the enrollment_token for a new TOTP secret
+func enrollMFALoginHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
+	var request struct {
+		EnrollmentToken string `json:"enrollment_token"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		log.Println("Invalid JSON request:", err)
+		http.Error(w, "Invalid JSON request", http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	doctor, ok := enrollmentSession(w, db, request.EnrollmentToken)
+	if !ok {
+		return
+	}
+	writeEnrollmentSecret(w, db, doctor.DoctorID)
+}
+
+// Second enrolment step at login: confirm a code from the new secret. Returns the recovery
+// codes together with the access token the password alone didn't earn.
+func confirmMFALoginHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
+	var request struct {
+		EnrollmentToken string `json:"enrollment_token"`
+		Code            string `json:"code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		log.Println("Invalid JSON request:", err)
+		http.Error(w, "Invalid JSON request", http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	doctor, ok := enrollmentSession(w, db, request.EnrollmentToken)
+	if !ok {
+		return
+	}
+
+	// Every attempt counts, so codes can't be guessed for the lifetime of the session
+	if _, err := db.Exec("UPDATE MfaChallenges SET Attempts = Attempts + 1 WHERE TokenHash = ?", hashToken(request.EnrollmentToken)); err != nil {
+		log.Println("Database update error:", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	codes, ok := enableMFA(w, db, doctor.DoctorID, request.Code)
+	if !ok {
+		return
+	}
+	if _, err := db.Exec("DELETE FROM MfaChallenges WHERE TokenHash = ?", hashToken(request.EnrollmentToken)); err != nil {
+		log.Println("Database delete error:", err)
+	}
+
+	guard := auth.LoginGuard{DB: db}
+	if err := guard.RecordSuccess(doctor.Email); err != nil {
+		log.Println("Error clearing failed logins:", err)
+	}
+
+	doctor.MFAEnabled = true
+	response, err := loginResponse(doctor)
+	if err != nil {
+		log.Println("Access token signing error:", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	response["message"] = "Multi-factor authentication enabled. Store these recovery codes somewhere safe; each can be used once."
+	response["recovery_codes"] = codes
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(response)
+}
+
+// Turn off MFA for the authenticated doctor. Requires a current TOTP or recovery code.
+func disableMFAHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
+	var request struct {
+		Code string `json:"code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		log.Println("Invalid JSON request:", err)
+		http.Error(w, "Invalid JSON request", http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	caller, _ := auth.CallerFromContext(r.Context())
+	if mfaRequired(caller.Role) {
+		http.Error(w, "Multi-factor authentication is required for this account", http.StatusForbidden)
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		log.Println("Database transaction error:", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	ok, err := checkSecondFactor(tx, caller.ID, request.Code)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Multi-factor authentication is not enabled", http.StatusBadRequest)
+		return
+	} else if err != nil {
+		log.Println("MFA verification error:", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		http.Error(w, "Invalid authentication code", http.StatusBadRequest)
+		return
+	}
+
+	if err := clearMFA(tx, caller.ID); err != nil {
+		log.Println("Database update error:", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		log.Println("Database commit error:", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Multi-factor authentication disabled"})
+}
+
+// Reset MFA for a doctor who has lost their authenticator and recovery codes. Admin only.
+func resetMFAHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
+	doctorID, ok := doctorIDFromPath(w, r)
+	if !ok {
+		return
+	}
+	caller, _ := auth.CallerFromContext(r.Context())
+
+	tx, err := db.Begin()
+	if err != nil {
+		log.Println("Database transaction error:", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	var email string
+	err = tx.QueryRow("SELECT Email FROM Doctors WHERE DoctorID = ? FOR UPDATE", doctorID).Scan(&email)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Doctor not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		log.Println("Database query error:", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	if err := clearMFA(tx, doctorID); err != nil {
+		log.Println("Database update error:", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if err := auth.WriteAudit(tx, "mfa_reset", email, auth.ClientIP(r), caller.ID, "MFA reset by admin"); err != nil {
+		log.Println("Audit log error:", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		log.Println("Database commit error:", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Multi-factor authentication reset"})
+}
+
+// Remove a doctor's MFA secret, recovery codes and pending login steps
+func clearMFA(tx *sql.Tx, doctorID int) error {
+	if _, err := tx.Exec("UPDATE Doctors SET MfaEnabled = FALSE, MfaSecret = NULL, MfaLastUsedStep = 0 WHERE DoctorID = ?", doctorID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM MfaRecoveryCodes WHERE DoctorID = ?", doctorID); err != nil {
+		return err
+	}
+	_, err := tx.Exec("DELETE FROM MfaChallenges WHERE DoctorID = ?", doctorID)
+	return err
+}
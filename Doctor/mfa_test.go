@@ -0,0 +1,52 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// RFC 6238 Appendix B test vectors for HMAC-SHA1 (8 digits, 30-second steps)
+func TestTOTPCodeRFC6238(t *testing.T) {
+	secret := []byte("12345678901234567890")
+
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "94287082"},
+		{1111111109, "07081804"},
+		{1111111111, "14050471"},
+		{1234567890, "89005924"},
+		{2000000000, "69279037"},
+		{20000000000, "65353130"},
+	}
+
+	for _, tt := range tests {
+		step := totpStep(time.Unix(tt.unix, 0))
+		if got := totpCode(secret, step, 8); got != tt.want {
+			t.Errorf("T=%d: totpCode = %s, want %s", tt.unix, got, tt.want)
+		}
+	}
+}
+
+func TestVerifyTOTPRejectsUsedSteps(t *testing.T) {
+	secret := []byte("12345678901234567890")
+	now := time.Unix(1111111111, 0)
+	code := totpCode(secret, totpStep(now), totpDigits)
+
+	step, ok := verifyTOTP(secret, code, now, 0)
+	if !ok || step != totpStep(now) {
+		t.Fatalf("verifyTOTP = %d, %v, want %d, true", step, ok, totpStep(now))
+	}
+	if _, ok := verifyTOTP(secret, code, now, step); ok {
+		t.Error("verifyTOTP accepted a code from an already used step")
+	}
+}
+
+func TestMFARequired(t *testing.T) {
+	for role, want := range map[string]bool{"doctor": true, "admin": true, "caregiver": false} {
+		if got := mfaRequired(role); got != want {
+			t.Errorf("mfaRequired(%q) = %v, want %v", role, got, want)
+		}
+	}
+}
@@ -4,10 +4,14 @@ import (
 	"crypto/tls"
 	"encoding/json"
 	"fmt"
+	"html"
 	"log"
 	"net/http"
+	"net/url"
 	"strconv"
 
+	"auth"
+
 	"gopkg.in/gomail.v2"
 )
 
@@ -18,12 +22,73 @@ type VisionResult struct {
 	Comments      string `json:"Comments"`
 }
 
+type PasswordReset struct {
+	Email string `json:"Email"`
+	Name  string `json:"Name"`
+	Token string `json:"Token"` // Reset token; the link is built here so callers can't choose where it points
+}
+
+type EmailVerification struct {
+	Email string `json:"Email"`
+	Name  string `json:"Name"`
+	Token string `json:"Token"` // Verification token; the link is built here like the reset link
+}
+
+const senderEmail = "newuploadedvideo@gmail.com"
+
+// Only the User service sends account emails, since it owns the tokens in the links
+const accountEmailSender = "user_service"
+
+// Page that accepts a reset token and a new password
+const resetPasswordPageURL = "http://localhost:5500/resetPassword.html"
+
+// Page that confirms an email verification token
+const verifyEmailPageURL = "http://localhost:5500/verifyEmail.html"
+
+// Send a message through Gmail SMTP
+func dialAndSend(m *gomail.Message) error {
+	d := gomail.NewDialer("smtp.gmail.com", 587, senderEmail, "agof rvwb lreo tups")
+	d.TLSConfig = &tls.Config{InsecureSkipVerify: true} // Needed for Gmail
+	return d.DialAndSend(m)
+}
+
+// Look up the emails of the patient's care team in the Doctor service, using the patient's own token
+func fetchCareTeamEmails(token string) ([]string, error) {
+	req, err := http.NewRequest(http.MethodPost, "http://localhost:5004/api/getCareTeam", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("care team lookup failed with status %d", resp.StatusCode)
+	}
+
+	var members []struct {
+		Email string `json:"email"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&members); err != nil {
+		return nil, err
+	}
+
+	emails := make([]string, 0, len(members))
+	for _, m := range members {
+		emails = append(emails, m.Email)
+	}
+	return emails, nil
+}
+
 // Function to send email using Gmail SMTP
-// Function to send email using Gmail SMTP
-func sendEmailToDoctor(result VisionResult) error {
+func sendEmailToDoctor(result VisionResult, recipients []string) error {
 	m := gomail.NewMessage()
-	m.SetHeader("From", "newuploadedvideo@gmail.com") // Your sender email
-	m.SetHeader("To", "s10247445@connect.np.edu.sg")  // Doctor's email
+	m.SetHeader("From", senderEmail) // Your sender email
+	m.SetHeader("To", recipients...) // Patient's care team
 	m.SetHeader("Subject", "Urgent: Vision Test Report for User ID "+strconv.Itoa(result.UserID))
 
 	// Email body with hyperlink to doctorHome.html
@@ -35,22 +100,55 @@ func sendEmailToDoctor(result VisionResult) error {
 		<p><strong>Comments:</strong> %s</p>
 		<p>Please review the report and advise accordingly.</p>
 		<p><a href="http://localhost:5500/doctorLogin.html" style="color: #007bff; font-weight: bold;">Click here to view the report</a></p>
-	`, result.UserID, result.LeftEyeScore, result.RightEyeScore, result.Comments)
+	`, result.UserID, result.LeftEyeScore, result.RightEyeScore, html.EscapeString(result.Comments))
 
 	m.SetBody("text/html", body)
 
-	// Configure Gmail SMTP settings
-	d := gomail.NewDialer("smtp.gmail.com", 587, "newuploadedvideo@gmail.com", "agof rvwb lreo tups")
-	d.TLSConfig = &tls.Config{InsecureSkipVerify: true} // Needed for Gmail
+	return dialAndSend(m)
+}
 
-	// Send email
-	if err := d.DialAndSend(m); err != nil {
-		return err
-	}
-	return nil
+// Send a password reset link to a patient
+func sendPasswordResetEmail(reset PasswordReset) error {
+	resetLink := resetPasswordPageURL + "?token=" + url.QueryEscape(reset.Token)
+
+	m := gomail.NewMessage()
+	m.SetHeader("From", senderEmail)
+	m.SetHeader("To", reset.Email)
+	m.SetHeader("Subject", "Reset your password")
+
+	body := fmt.Sprintf(`
+		<p>Hi %s,</p>
+		<p>We received a request to reset your password. This link expires in 30 minutes and can only be used once.</p>
+		<p><a href="%s" style="color: #007bff; font-weight: bold;">Reset your password</a></p>
+		<p>If you didn't request this, you can ignore this email.</p>
+	`, html.EscapeString(reset.Name), html.EscapeString(resetLink))
+
+	m.SetBody("text/html", body)
+	return dialAndSend(m)
 }
 
-// API Endpoint to receive and send emails
+// Send an email verification link to a newly registered patient
+func sendVerificationEmail(verification EmailVerification) error {
+	verifyLink := verifyEmailPageURL + "?token=" + url.QueryEscape(verification.Token)
+
+	m := gomail.NewMessage()
+	m.SetHeader("From", senderEmail)
+	m.SetHeader("To", verification.Email)
+	m.SetHeader("Subject", "Please verify your email address")
+
+	body := fmt.Sprintf(`
+		<p>Hi %s,</p>
+		<p>Please confirm that this is your email address so that we can reach you about your health assessments. This link expires in 24 hours.</p>
+		<p><a href="%s" style="color: #007bff; font-weight: bold;">Verify your email address</a></p>
+		<p>If you didn't create an account, you can ignore this email.</p>
+	`, html.EscapeString(verification.Name), html.EscapeString(verifyLink))
+
+	m.SetBody("text/html", body)
+	return dialAndSend(m)
+}
+
+// API Endpoint to receive and send emails. Called with the patient's token, and the report only
+// goes to that patient's care team as recorded in the Doctor service.
 func handleSendReportToDoctor(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
@@ -64,8 +162,27 @@ func handleSendReportToDoctor(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Patients can only send their own reports
+	caller, _ := auth.CallerFromContext(r.Context())
+	if result.UserID != 0 && result.UserID != caller.ID {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	result.UserID = caller.ID
+
+	recipients, err := fetchCareTeamEmails(auth.BearerToken(r))
+	if err != nil {
+		log.Println("Error fetching care team for vision report:", err)
+		http.Error(w, "Failed to look up care team", http.StatusBadGateway)
+		return
+	}
+	if len(recipients) == 0 {
+		http.Error(w, "No care team assigned to this patient", http.StatusUnprocessableEntity)
+		return
+	}
+
 	// Send email
-	err = sendEmailToDoctor(result)
+	err = sendEmailToDoctor(result, recipients)
 	if err != nil {
 		log.Println("Failed to send email:", err)
 		http.Error(w, "Failed to send report to doctor", http.StatusInternalServerError)
@@ -74,11 +191,81 @@ func handleSendReportToDoctor(w http.ResponseWriter, r *http.Request) {
 
 	// Success response
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{"message": "Report sent successfully to doctor"})
+	json.NewEncoder(w).Encode(map[string]string{"message": "Report sent successfully to care team"})
+}
+
+// API Endpoint to send a password reset link. Only the User service may call it.
+func handleSendPasswordReset(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	caller, _ := auth.CallerFromContext(r.Context())
+	if caller.Service != accountEmailSender {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	var reset PasswordReset
+	if err := json.NewDecoder(r.Body).Decode(&reset); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if reset.Email == "" || reset.Token == "" {
+		http.Error(w, "Email and Token are required", http.StatusBadRequest)
+		return
+	}
+
+	if err := sendPasswordResetEmail(reset); err != nil {
+		log.Println("Failed to send email:", err)
+		http.Error(w, "Failed to send password reset email", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Password reset email sent"})
+}
+
+// API Endpoint to send an email verification link. Only the User service may call it.
+func handleSendEmailVerification(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	caller, _ := auth.CallerFromContext(r.Context())
+	if caller.Service != accountEmailSender {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	var verification EmailVerification
+	if err := json.NewDecoder(r.Body).Decode(&verification); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if verification.Email == "" || verification.Token == "" {
+		http.Error(w, "Email and Token are required", http.StatusBadRequest)
+		return
+	}
+
+	if err := sendVerificationEmail(verification); err != nil {
+		log.Println("Failed to send email:", err)
+		http.Error(w, "Failed to send verification email", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Verification email sent"})
 }
 
 func main() {
-	http.HandleFunc("/sendReportToDoctor", handleSendReportToDoctor)
+	verifier := &auth.Verifier{Secret: auth.SecretFromEnv(), CareTeamURL: "http://localhost:5004"}
+
+	http.HandleFunc("/sendReportToDoctor", verifier.RequirePermission(auth.PermSubmitAssessment, handleSendReportToDoctor))
+	http.HandleFunc("/sendPasswordReset", verifier.RequirePermission(auth.PermSendAccountEmails, handleSendPasswordReset))
+	http.HandleFunc("/sendEmailVerification", verifier.RequirePermission(auth.PermSendAccountEmails, handleSendEmailVerification))
 	log.Println("Email microservice running on port 8090")
 	log.Fatal(http.ListenAndServe(":8090", nil)) // Running on port 8090
 }
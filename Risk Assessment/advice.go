@@ -0,0 +1,151 @@
+package main
+
+// Languages advice is available in, matching the questionnaire
+var supportedLanguages = map[string]bool{"English": true, "Chinese": true, "Malay": true, "Tamil": true}
+
+// Targeted advice triggered by a specific answer
+type Advice struct {
+	ID         string `json:"id"`
+	QuestionID int    `json:"question_id"` // First answer that triggered it
+	Text       string `json:"text"`
+}
+
+// Gives advice when a question is answered with one of the listed options
+type adviceRule struct {
+	questionID int
+	answers    []int
+	advice     string
+}
+
+// Rules per instrument, most important first. lb-10 rules refer to the questionnaire's question IDs.
+// Morse has none: it is scored by clinicians, and its bands already set the interventions.
+var adviceRules = map[string][]adviceRule{
+	"lb-10": {
+		{3, []int{2, 3}, "fall_review"}, // Falls in the past year: 1-2, 3 or more
+		{6, []int{1}, "fall_review"},    // Fall in the past 6 months
+		{8, []int{1, 3}, "medication_review"},
+		{1, []int{1}, "dizziness"},
+		{7, []int{2}, "strength_exercises"}, // Can't stand up without using hands
+		{9, []int{2}, "balance_programme"},  // No regular exercise
+		{2, []int{2, 3}, "balance_programme"},
+		{5, []int{2, 3, 4}, "home_safety"},
+		{4, []int{2, 3, 4}, "mobility_aid_check"},
+		{10, []int{1, 3}, "foot_care"},
+	},
+	"steadi": {
+		{1, []int{1}, "fall_review"},
+		{10, []int{1}, "medication_review"},
+		{11, []int{1}, "medication_review"},
+		{6, []int{1}, "strength_exercises"},
+		{7, []int{1}, "strength_exercises"},
+		{3, []int{1}, "balance_programme"},
+		{4, []int{1}, "home_safety"},
+		{2, []int{1}, "mobility_aid_check"},
+		{9, []int{1}, "foot_care"},
+		{8, []int{1}, "continence"},
+		{5, []int{1}, "fear_of_falling"},
+		{12, []int{1}, "mood"},
+	},
+	"short-fes-i": {
+		{1, []int{3, 4}, "fear_of_falling"}, // Fairly or very concerned about any activity
+		{2, []int{3, 4}, "fear_of_falling"},
+		{3, []int{3, 4}, "fear_of_falling"},
+		{4, []int{3, 4}, "fear_of_falling"},
+		{5, []int{3, 4}, "fear_of_falling"},
+		{6, []int{3, 4}, "fear_of_falling"},
+		{7, []int{3, 4}, "fear_of_falling"},
+	},
+}
+
+// Advice text by ID and language
+var adviceText = map[string]map[string]string{
+	"fall_review": {
+		"English": "Tell your doctor about your falls so they can look for the cause and help prevent another one.",
+		"Chinese": "请告诉医生您的跌倒情况，以便查找原因并帮助预防再次跌倒。",
+		"Malay":   "Beritahu doktor anda tentang kejadian jatuh anda supaya puncanya dapat dikenal pasti dan kejadian seterusnya dapat dicegah.",
+		"Tamil":   "நீங்கள் கீழே விழுந்ததைப் பற்றி உங்கள் மருத்துவரிடம் சொல்லுங்கள்; அவர் காரணத்தைக் கண்டறிந்து மீண்டும் விழாமல் தடுக்க உதவுவார்.",
+	},
+	"medication_review": {
+		"English": "Ask your doctor or pharmacist to review your medicines, especially any that make you dizzy or drowsy.",
+		"Chinese": "请医生或药剂师检查您正在服用的药物，尤其是会引起头晕或嗜睡的药物。",
+		"Malay":   "Minta doktor atau ahli farmasi anda menyemak ubat-ubatan anda, terutamanya ubat yang menyebabkan pening atau mengantuk.",
+		"Tamil":   "உங்கள் மருந்துகளை, குறிப்பாக மயக்கம் அல்லது தூக்கக் கலக்கத்தை ஏற்படுத்தும் மருந்துகளை, மருத்துவர் அல்லது மருந்தாளரிடம் மறுபரிசீலனை செய்யச் சொல்லுங்கள்.",
+	},
+	"dizziness": {
+		"English": "See your doctor about your dizziness. Until then, get up slowly from lying or sitting and hold on to something steady.",
+		"Chinese": "请就头晕问题咨询医生。在此之前，从躺或坐的姿势起身时要慢，并扶住稳固的物体。",
+		"Malay":   "Jumpa doktor tentang masalah pening anda. Sementara itu, bangun perlahan-lahan dari baring atau duduk dan berpaut pada sesuatu yang kukuh.",
+		"Tamil":   "உங்கள் மயக்கம் குறித்து மருத்துவரைச் சந்தியுங்கள். அதுவரை, படுத்த அல்லது அமர்ந்த நிலையிலிருந்து மெதுவாக எழுந்து, உறுதியான ஒன்றைப் பிடித்துக்கொள்ளுங்கள்.",
+	},
+	"strength_exercises": {
+		"English": "Do leg-strengthening exercises, such as standing up from a chair without using your hands, several times a day.",
+		"Chinese": "每天做几次腿部力量练习，例如不用手从椅子上站起来。",
+		"Malay":   "Lakukan senaman menguatkan kaki, seperti bangun dari kerusi tanpa menggunakan tangan, beberapa kali sehari.",
+		"Tamil":   "கைகளைப் பயன்படுத்தாமல் நாற்காலியிலிருந்து எழுந்திருப்பது போன்ற கால் வலுப்படுத்தும் பயிற்சிகளை நாளுக்குப் பலமுறை செய்யுங்கள்.",
+	},
+	"balance_programme": {
+		"English": "Join a balance exercise programme, such as Tai Chi or a community falls prevention class, at least twice a week.",
+		"Chinese": "参加平衡训练课程，例如太极或社区防跌课程，每周至少两次。",
+		"Malay":   "Sertai program senaman keseimbangan, seperti Tai Chi atau kelas pencegahan jatuh di komuniti, sekurang-kurangnya dua kali seminggu.",
+		"Tamil":   "வாரத்திற்கு குறைந்தது இரண்டு முறை, தை சி அல்லது சமூக விழுதல் தடுப்பு வகுப்பு போன்ற சமநிலைப் பயிற்சித் திட்டத்தில் சேருங்கள்.",
+	},
+	"mobility_aid_check": {
+		"English": "Have a physiotherapist check that your walking aid is the right type and height, and is in good condition.",
+		"Chinese": "请物理治疗师检查您的助行器具类型和高度是否合适，以及是否状况良好。",
+		"Malay":   "Minta ahli fisioterapi memeriksa sama ada alat bantuan berjalan anda jenis dan ketinggian yang betul serta dalam keadaan baik.",
+		"Tamil":   "உங்கள் நடை உதவிக் கருவி சரியான வகையும் உயரமும் கொண்டதா, நல்ல நிலையில் உள்ளதா என்பதை ஒரு பிசியோதெரபிஸ்ட் சரிபார்க்கட்டும்.",
+	},
+	"home_safety": {
+		"English": "Make your home safer: clear clutter and loose rugs, add grab bars in the bathroom and use night lights.",
+		"Chinese": "让家居更安全：清理杂物和松动的地毯，在浴室安装扶手，并使用夜灯。",
+		"Malay":   "Jadikan rumah anda lebih selamat: alihkan barang bersepah dan permaidani longgar, pasang palang pemegang di bilik air dan gunakan lampu malam.",
+		"Tamil":   "உங்கள் வீட்டைப் பாதுகாப்பாக்குங்கள்: தேவையற்ற பொருட்களையும் தளர்வான விரிப்புகளையும் அகற்றுங்கள், குளியலறையில் பிடிமானக் கம்பிகளைப் பொருத்துங்கள், இரவு விளக்குகளைப் பயன்படுத்துங்கள்.",
+	},
+	"foot_care": {
+		"English": "Have your feet checked by a doctor or podiatrist, and wear well-fitting shoes with non-slip soles.",
+		"Chinese": "请医生或足科医生检查您的双脚，并穿合脚、鞋底防滑的鞋子。",
+		"Malay":   "Dapatkan pemeriksaan kaki daripada doktor atau pakar podiatri, dan pakai kasut yang padan dengan tapak yang tidak licin.",
+		"Tamil":   "உங்கள் பாதங்களை மருத்துவர் அல்லது பாத மருத்துவரிடம் பரிசோதியுங்கள்; சரியாகப் பொருந்தும், வழுக்காத அடிப்பகுதி கொண்ட காலணிகளை அணியுங்கள்.",
+	},
+	"continence": {
+		"English": "Talk to your doctor about needing to rush to the toilet, and keep the way to the toilet clear and lit at night.",
+		"Chinese": "如果您经常需要赶去厕所，请咨询医生，并保持通往厕所的路线通畅，夜间有照明。",
+		"Malay":   "Bincang dengan doktor anda tentang keperluan tergesa-gesa ke tandas, dan pastikan laluan ke tandas bebas halangan serta terang pada waktu malam.",
+		"Tamil":   "கழிப்பறைக்கு அவசரமாகச் செல்ல வேண்டியிருப்பது பற்றி மருத்துவரிடம் பேசுங்கள்; கழிப்பறைக்குச் செல்லும் வழியைத் தடையின்றியும் இரவில் வெளிச்சத்துடனும் வைத்திருங்கள்.",
+	},
+	"mood": {
+		"English": "Let your doctor know if you often feel sad or low. Support is available and can also lower your risk of falling.",
+		"Chinese": "如果您经常感到难过或情绪低落，请告诉医生。获得支持也有助于降低跌倒风险。",
+		"Malay":   "Beritahu doktor anda jika anda sering berasa sedih atau murung. Sokongan boleh didapati dan juga boleh mengurangkan risiko jatuh.",
+		"Tamil":   "நீங்கள் அடிக்கடி சோகமாக அல்லது மனச்சோர்வாக உணர்ந்தால் உங்கள் மருத்துவரிடம் தெரிவியுங்கள். உதவி கிடைக்கும், அது விழும் அபாயத்தையும் குறைக்கும்.",
+	},
+	"fear_of_falling": {
+		"English": "Talk to your doctor about your worry about falling. Avoiding activity can weaken you, and a balance programme can help rebuild confidence.",
+		"Chinese": "请与医生谈谈您对跌倒的担忧。减少活动会让身体变弱，平衡训练有助于重建信心。",
+		"Malay":   "Bincang dengan doktor anda tentang kebimbangan anda terhadap jatuh. Mengelak aktiviti boleh melemahkan badan, dan program keseimbangan boleh membantu memulihkan keyakinan.",
+		"Tamil":   "விழுவது குறித்த உங்கள் கவலையைப் பற்றி மருத்துவரிடம் பேசுங்கள். செயல்பாடுகளைத் தவிர்ப்பது உடலைப் பலவீனப்படுத்தும்; சமநிலைப் பயிற்சி நம்பிக்கையை மீண்டும் பெற உதவும்.",
+	},
+}
+
+// Advice for a validated answer set, in rule order, each piece of advice given once
+func adviceFor(instrument string, answers map[int]int, language string) []Advice {
+	advice := []Advice{}
+	given := map[string]bool{}
+	for _, rule := range adviceRules[instrument] {
+		if given[rule.advice] {
+			continue
+		}
+		answer, ok := answers[rule.questionID]
+		if !ok {
+			continue
+		}
+		for _, option := range rule.answers {
+			if option == answer {
+				advice = append(advice, Advice{ID: rule.advice, QuestionID: rule.questionID, Text: adviceText[rule.advice][language]})
+				given[rule.advice] = true
+				break
+			}
+		}
+	}
+	return advice
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+)
+
+const (
+	maxBatchItems = 100000
+	maxBatchBytes = 64 << 20 // 64 MB
+	ndjsonType    = "application/x-ndjson"
+)
+
+// One answer set in a batch. ID is echoed back so callers can match results to their records.
+type batchItem struct {
+	ID json.RawMessage `json:"id,omitempty"`
+	AnalyzeRequest
+}
+
+// Result for one batch item, in input order
+type batchResult struct {
+	Index  int             `json:"index"`
+	ID     json.RawMessage `json:"id,omitempty"`
+	Result *ScoreResult    `json:"result,omitempty"`
+	Error  string          `json:"error,omitempty"`
+	Errors []AnswerError   `json:"errors,omitempty"`
+}
+
+// Score many answer sets in one request. The body is either a JSON array or NDJSON (one object
+// per line, Content-Type application/x-ndjson). Results are streamed back as each item is scored,
+// as NDJSON when the request was NDJSON or asks for it with Accept, and as a JSON array otherwise.
+// A bad item gets an error entry and doesn't stop the batch.
+func batchAnalyzeHandler(w http.ResponseWriter, r *http.Request, store *ModelStore) {
+	contentType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	ndjsonIn := contentType == ndjsonType
+	accept, _, _ := mime.ParseMediaType(r.Header.Get("Accept"))
+	ndjsonOut := ndjsonIn || accept == ndjsonType
+
+	// Keep reading the request while results are streamed back; HTTP/1 otherwise closes the body
+	// once the response starts
+	controller := http.NewResponseController(w)
+	controller.EnableFullDuplex()
+
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBatchBytes))
+	if !ndjsonIn {
+		if token, err := decoder.Token(); err != nil || token != json.Delim('[') {
+			http.Error(w, "Expected a JSON array of answer sets, or NDJSON with Content-Type "+ndjsonType, http.StatusBadRequest)
+			return
+		}
+	}
+
+	if ndjsonOut {
+		w.Header().Set("Content-Type", ndjsonType)
+	} else {
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, "[")
+	}
+	encoder := json.NewEncoder(w)
+
+	write := func(result batchResult) {
+		if !ndjsonOut && result.Index > 0 {
+			io.WriteString(w, ",")
+		}
+		encoder.Encode(result)
+		controller.Flush()
+	}
+
+	for index := 0; ; index++ {
+		if !ndjsonIn && !decoder.More() {
+			break
+		}
+
+		var item batchItem
+		err := decoder.Decode(&item)
+		if ndjsonIn && err == io.EOF {
+			break
+		}
+		if err != nil {
+			// The rest of the stream can't be read reliably, so stop here
+			write(batchResult{Index: index, Error: "Invalid JSON: " + err.Error()})
+			break
+		}
+		if index >= maxBatchItems {
+			write(batchResult{Index: index, Error: fmt.Sprintf("Batch limit of %d items reached; the remaining items were not scored", maxBatchItems)})
+			break
+		}
+
+		entry := batchResult{Index: index, ID: item.ID}
+		result, err := analyze(store, item.AnalyzeRequest)
+		var invalid *ValidationError
+		if errors.As(err, &invalid) {
+			entry.Error = "Some answers are missing or invalid"
+			entry.Errors = invalid.Errors
+		} else if err != nil {
+			entry.Error = err.Error()
+		} else {
+			entry.Result = &result
+		}
+		write(entry)
+	}
+
+	if !ndjsonOut {
+		io.WriteString(w, "]\n")
+	}
+}
+
+// One changed answer in a what-if scenario
+type answerChange struct {
+	QuestionID  int    `json:"question_id"`
+	Factor      string `json:"factor"`
+	From        int    `json:"from"`
+	To          int    `json:"to"`
+	PointsDelta int    `json:"points_delta"`
+}
+
+// Score a baseline answer set and the same answers with some overridden, e.g. "what if the
+// patient started exercising", and return both results with the difference
+func whatIfHandler(w http.ResponseWriter, r *http.Request, store *ModelStore) {
+	var req struct {
+		AnalyzeRequest
+		Overrides map[int]int `json:"overrides"` // {question_id: option_index} replacing baseline answers
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON request", http.StatusBadRequest)
+		return
+	}
+	if len(req.Overrides) == 0 {
+		http.Error(w, "No overrides provided", http.StatusBadRequest)
+		return
+	}
+
+	baseline, err := analyze(store, req.AnalyzeRequest)
+	if err != nil {
+		writeAnalyzeError(w, err)
+		return
+	}
+
+	scenarioRequest := req.AnalyzeRequest
+	scenarioRequest.Answers = map[int]int{}
+	for questionID, answer := range req.Answers {
+		scenarioRequest.Answers[questionID] = answer
+	}
+	for questionID, answer := range req.Overrides {
+		scenarioRequest.Answers[questionID] = answer
+	}
+	// Score against the same model version as the baseline, even if a new one is published meanwhile
+	scenarioRequest.ModelVersion = baseline.ModelVersion
+
+	scenario, err := analyze(store, scenarioRequest)
+	if err != nil {
+		writeAnalyzeError(w, err)
+		return
+	}
+
+	baselinePoints := map[int]int{}
+	for _, c := range baseline.Breakdown {
+		baselinePoints[c.QuestionID] = c.Points
+	}
+	changes := []answerChange{}
+	for _, c := range scenario.Breakdown {
+		from := req.Answers[c.QuestionID]
+		if from == c.Answer {
+			continue
+		}
+		changes = append(changes, answerChange{
+			QuestionID:  c.QuestionID,
+			Factor:      c.Factor,
+			From:        from,
+			To:          c.Answer,
+			PointsDelta: c.Points - baselinePoints[c.QuestionID],
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"baseline":           baseline,
+		"what_if":            scenario,
+		"score_delta":        scenario.TotalScore - baseline.TotalScore,
+		"risk_level_changed": scenario.RiskLevel != baseline.RiskLevel,
+		"changes":            changes,
+	})
+}
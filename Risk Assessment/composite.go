@@ -0,0 +1,246 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"time"
+
+	"auth"
+)
+
+// Composite score inputs and weights. Each source is turned into a risk between 0 and 1:
+//   - questionnaire: latest self-assessment total divided by the highest score its model allows
+//   - vision: contrast deficit of the worse eye, (5 - lower eye score) / 5, from the 0-5 vision test
+//   - age: 0 under 60, then 0.25 per decade (60-69: 0.25, 70-79: 0.5, 80-89: 0.75, 90+: 1)
+//
+// The composite is the weighted average of the sources that are present, scaled to 0-100.
+// Missing sources are left out and the remaining weights rescaled, and the response lists them.
+const (
+	questionnaireWeight = 0.6
+	visionWeight        = 0.2
+	ageWeight           = 0.2
+
+	maxVisionScore = 5
+)
+
+// Composite risk bands on the 0-100 scale, below maxScore. They line up with the lb-10 v1 bands
+// (Low up to 5 of 24, Moderate up to 10), so the questionnaire alone gives the same level.
+var compositeBands = []struct {
+	level    string
+	maxScore float64
+}{
+	{"Low", 25},
+	{"Moderate", 45},
+	{"High", math.Inf(1)},
+}
+
+// Service endpoints the composite score reads from, called with the caller's own token
+const (
+	lastAssessmentURL = "http://localhost:5000/api/getLastAssessment"
+	latestVisionURL   = "http://localhost:8088/getLatestResult"
+	userDetailsURL    = "http://localhost:5001/api/getUserDetails"
+)
+
+var serviceClient = &http.Client{Timeout: 10 * time.Second}
+
+// One input to the composite score
+type compositeSource struct {
+	Source  string                 `json:"source"`
+	Present bool                   `json:"present"`
+	Weight  float64                `json:"weight"`
+	Risk    *float64               `json:"risk,omitempty"` // 0 to 1
+	Details map[string]interface{} `json:"details,omitempty"`
+}
+
+// Call another service and decode its JSON response. A 404 means the patient has no data there.
+func fetchServiceJSON(method, url, token string, body interface{}, out interface{}) (bool, error) {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return false, err
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequest(method, url, reader)
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := serviceClient.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return false, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("%s %s returned status %d", method, url, resp.StatusCode)
+	}
+	return true, json.NewDecoder(resp.Body).Decode(out)
+}
+
+// Age in whole years on the given day
+func ageOn(dateOfBirth, day time.Time) int {
+	age := day.Year() - dateOfBirth.Year()
+	if day.Month() < dateOfBirth.Month() || (day.Month() == dateOfBirth.Month() && day.Day() < dateOfBirth.Day()) {
+		age--
+	}
+	return age
+}
+
+// Risk contributed by age
+func ageRisk(age int) float64 {
+	if age < 60 {
+		return 0
+	}
+	return math.Min(float64(age/10-5)*0.25, 1)
+}
+
+// Combine the patient's latest questionnaire, contrast vision result and age into one score
+func compositeRiskHandler(w http.ResponseWriter, r *http.Request, store *ModelStore) {
+	var req struct {
+		UserID int `json:"user_id"` // Optional for patients
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid JSON request", http.StatusBadRequest)
+			return
+		}
+	}
+
+	userID, ok := auth.PatientID(w, r, req.UserID)
+	if !ok {
+		return
+	}
+	token := auth.BearerToken(r)
+
+	// Latest self-assessment
+	questionnaire := compositeSource{Source: "questionnaire", Weight: questionnaireWeight}
+	var assessment struct {
+		AssessmentID int    `json:"id"`
+		TotalScore   int    `json:"totalScore"`
+		RiskLevel    string `json:"riskLevel"`
+		ModelVersion int    `json:"modelVersion"`
+	}
+	found, err := fetchServiceJSON(http.MethodPost, lastAssessmentURL, token, map[string]int{"user_id": userID}, &assessment)
+	if err != nil {
+		log.Println("Composite risk: self-assessment lookup error:", err)
+		http.Error(w, "Failed to fetch self-assessment data", http.StatusBadGateway)
+		return
+	}
+	if found {
+		// Assessments from before model versioning were scored with the rules that became version 1
+		version := assessment.ModelVersion
+		if version == 0 {
+			version = 1
+		}
+		model, ok := store.Get(version)
+		if !ok {
+			model = store.Active()
+		}
+		risk := math.Min(float64(assessment.TotalScore)/float64(model.MaxScore()), 1)
+		questionnaire.Present = true
+		questionnaire.Risk = &risk
+		questionnaire.Details = map[string]interface{}{
+			"assessment_id": assessment.AssessmentID,
+			"total_score":   assessment.TotalScore,
+			"max_score":     model.MaxScore(),
+			"risk_level":    assessment.RiskLevel,
+			"model_version": model.Version,
+		}
+	}
+
+	// Latest contrast vision test
+	vision := compositeSource{Source: "vision", Weight: visionWeight}
+	var visionResult struct {
+		LeftEyeScore  int    `json:"LeftEyeScore"`
+		RightEyeScore int    `json:"RightEyeScore"`
+		CreatedAt     string `json:"CreatedAt"`
+	}
+	found, err = fetchServiceJSON(http.MethodGet, fmt.Sprintf("%s?userID=%d", latestVisionURL, userID), token, nil, &visionResult)
+	if err != nil {
+		log.Println("Composite risk: vision lookup error:", err)
+		http.Error(w, "Failed to fetch vision data", http.StatusBadGateway)
+		return
+	}
+	if found {
+		worse := min(visionResult.LeftEyeScore, visionResult.RightEyeScore)
+		risk := math.Max(0, math.Min(float64(maxVisionScore-worse)/maxVisionScore, 1))
+		vision.Present = true
+		vision.Risk = &risk
+		vision.Details = map[string]interface{}{
+			"left_eye_score":  visionResult.LeftEyeScore,
+			"right_eye_score": visionResult.RightEyeScore,
+			"tested_at":       visionResult.CreatedAt,
+		}
+	}
+
+	// Age from the patient's profile
+	age := compositeSource{Source: "age", Weight: ageWeight}
+	var profile struct {
+		DateOfBirth time.Time `json:"date_of_birth"`
+	}
+	found, err = fetchServiceJSON(http.MethodPost, userDetailsURL, token, map[string]int{"user_id": userID}, &profile)
+	if err != nil {
+		log.Println("Composite risk: user details lookup error:", err)
+		http.Error(w, "Failed to fetch user details", http.StatusBadGateway)
+		return
+	}
+	if found && !profile.DateOfBirth.IsZero() {
+		years := ageOn(profile.DateOfBirth, time.Now())
+		risk := ageRisk(years)
+		age.Present = true
+		age.Risk = &risk
+		age.Details = map[string]interface{}{"age": years}
+	}
+
+	// Weighted average over the sources that are present
+	sources := []compositeSource{questionnaire, vision, age}
+	present, missing := []string{}, []string{}
+	weighted, totalWeight := 0.0, 0.0
+	for _, source := range sources {
+		if !source.Present {
+			missing = append(missing, source.Source)
+			continue
+		}
+		present = append(present, source.Source)
+		weighted += source.Weight * *source.Risk
+		totalWeight += source.Weight
+	}
+	if totalWeight == 0 {
+		http.Error(w, "No risk data found for this user", http.StatusNotFound)
+		return
+	}
+
+	score := math.Round(weighted / totalWeight * 100)
+	riskLevel := compositeBands[len(compositeBands)-1].level
+	for _, band := range compositeBands {
+		if score < band.maxScore {
+			riskLevel = band.level
+			break
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"user_id":         userID,
+		"composite_score": score,
+		"risk_level":      riskLevel,
+		"sources":         sources,
+		"present":         present,
+		"missing":         missing,
+	})
+}
@@ -0,0 +1,50 @@
+package main
+
+// Short FES-I (Kempen et al., 2008): concern about falling during seven activities, each rated
+// 1 (not at all concerned) to 4 (very concerned), 7 to 28 points. Bands follow Delbaere et al.
+// (2010): 7-8 low, 9-13 moderate, 14-28 high concern.
+type shortFESIScorer struct{}
+
+func concernOptions() []Option {
+	return []Option{
+		{Index: 1, Label: "Not at all concerned", Points: 1},
+		{Index: 2, Label: "Somewhat concerned", Points: 2},
+		{Index: 3, Label: "Fairly concerned", Points: 3},
+		{Index: 4, Label: "Very concerned", Points: 4},
+	}
+}
+
+var shortFESIItems = []Item{
+	{ID: 1, Factor: "concern when dressing", Text: "Getting dressed or undressed", Options: concernOptions()},
+	{ID: 2, Factor: "concern when bathing", Text: "Taking a bath or shower", Options: concernOptions()},
+	{ID: 3, Factor: "concern with chairs", Text: "Getting in or out of a chair", Options: concernOptions()},
+	{ID: 4, Factor: "concern on stairs", Text: "Going up or down stairs", Options: concernOptions()},
+	{ID: 5, Factor: "concern when reaching", Text: "Reaching for something above your head or on the ground", Options: concernOptions()},
+	{ID: 6, Factor: "concern on slopes", Text: "Walking up or down a slope", Options: concernOptions()},
+	{ID: 7, Factor: "concern going out", Text: "Going out to a social event (e.g. religious service, family gathering or club meeting)", Options: concernOptions()},
+}
+
+func (shortFESIScorer) ID() string    { return "short-fes-i" }
+func (shortFESIScorer) Name() string  { return "Short Falls Efficacy Scale-International" }
+func (shortFESIScorer) Items() []Item { return shortFESIItems }
+
+func (s shortFESIScorer) Score(answers map[int]int) (ScoreResult, error) {
+	scores, err := scoreItems(shortFESIItems, answers)
+	if err != nil {
+		return ScoreResult{}, err
+	}
+
+	result := ScoreResult{Instrument: s.ID(), TotalScore: scores.Total, Breakdown: scores.Breakdown, TopFactors: scores.TopFactors}
+	switch {
+	case scores.Total <= 8:
+		result.RiskLevel = "Low"
+		result.Recommendation = "Low concern about falling. Keep up your usual activities."
+	case scores.Total <= 13:
+		result.RiskLevel = "Moderate"
+		result.Recommendation = "Some concern about falling. A balance and strength programme can help build confidence."
+	default:
+		result.RiskLevel = "High"
+		result.Recommendation = "High concern about falling. Discuss it with your doctor, as fear of falling can lead to avoiding activity and a higher fall risk."
+	}
+	return result, nil
+}
@@ -0,0 +1,27 @@
+package main
+
+import "testing"
+
+// Options: 1 = not at all, 2 = somewhat, 3 = fairly, 4 = very concerned
+func TestShortFESIScore(t *testing.T) {
+	runScoreCases(t, shortFESIScorer{}, []scoreCase{
+		{"not at all concerned scores the 7 point minimum", answersFor(1, 1, 1, 1, 1, 1, 1), 7, "Low"},
+		{"very concerned scores the 28 point maximum", answersFor(4, 4, 4, 4, 4, 4, 4), 28, "High"},
+		{"8 points is low", answersFor(1, 1, 1, 2, 1, 1, 1), 8, "Low"},
+		{"9 points is moderate", answersFor(1, 1, 1, 2, 2, 1, 1), 9, "Moderate"},
+		{"13 points is moderate", answersFor(2, 2, 2, 2, 2, 2, 1), 13, "Moderate"},
+		{"14 points is high", answersFor(2, 2, 2, 2, 2, 2, 2), 14, "High"},
+	})
+}
+
+func TestShortFESIRejectsInvalidAnswers(t *testing.T) {
+	missing := answersFor(1, 1, 1, 1, 1, 1, 1)
+	delete(missing, 7)
+
+	runRejectCases(t, shortFESIScorer{}, []rejectCase{
+		{"option 0", answersFor(0, 1, 1, 1, 1, 1, 1), 1, answerInvalidOption},
+		{"option 5", answersFor(1, 1, 5, 1, 1, 1, 1), 3, answerInvalidOption},
+		{"unanswered activity", missing, 7, answerMissing},
+		{"activity 8", answersFor(1, 1, 1, 1, 1, 1, 1, 1), 8, answerUnknownQuestion},
+	})
+}
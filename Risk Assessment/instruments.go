@@ -0,0 +1,195 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Default instrument: the service's own questionnaire, scored with the active risk model
+const defaultInstrument = "lb-10"
+
+// Result of scoring one set of answers
+type ScoreResult struct {
+	Instrument     string `json:"instrument"`
+	TotalScore     int    `json:"total_score"`
+	RiskLevel      string `json:"risk_level"`
+	Recommendation string `json:"recommendation"`
+	ModelVersion   int    `json:"model_version,omitempty"`
+
+	Breakdown  []Contribution `json:"breakdown"`   // One entry per answered question
+	TopFactors []string       `json:"top_factors"` // Factors that added the most risk, highest first
+	Advice     []Advice       `json:"advice"`      // Targeted advice for specific answers, localised
+}
+
+// Points one answer contributed to the total
+type Contribution struct {
+	QuestionID int    `json:"question_id"`
+	Factor     string `json:"factor"`
+	Answer     int    `json:"answer"`
+	Points     int    `json:"points"`
+	MaxPoints  int    `json:"max_points"`
+}
+
+// Number of factors reported in TopFactors
+const topFactorCount = 3
+
+// One answer option of an instrument item
+type Option struct {
+	Index  int    `json:"index"` // 1-based, as sent in answers
+	Label  string `json:"label,omitempty"`
+	Points int    `json:"points"`
+}
+
+// One question of an instrument
+type Item struct {
+	ID       int      `json:"id"`
+	Text     string   `json:"text"`
+	Factor   string   `json:"factor"` // Risk factor the item measures, e.g. "prior falls"
+	Optional bool     `json:"optional,omitempty"`
+	Options  []Option `json:"options"`
+}
+
+// Option with the given index, if the item has one
+func (item Item) Option(index int) (Option, bool) {
+	for _, option := range item.Options {
+		if option.Index == index {
+			return option, true
+		}
+	}
+	return Option{}, false
+}
+
+// Scorer scores answers ({item_id: option_index}) for one fall-risk instrument
+type Scorer interface {
+	ID() string
+	Name() string
+	Items() []Item
+	Score(answers map[int]int) (ScoreResult, error)
+}
+
+// Published instruments with fixed scoring. These follow the validated instruments exactly,
+// so unlike lb-10 they are not configurable.
+var instruments = map[string]Scorer{
+	"steadi":      steadiScorer{},
+	"morse":       morseScorer{},
+	"short-fes-i": shortFESIScorer{},
+}
+
+// Find the scorer for an instrument. modelVersion only applies to lb-10; 0 means the active model.
+func lookupScorer(instrument string, store *ModelStore, modelVersion int) (Scorer, error) {
+	if instrument == "" || instrument == defaultInstrument {
+		model := store.Active()
+		if modelVersion != 0 {
+			var ok bool
+			if model, ok = store.Get(modelVersion); !ok {
+				return nil, fmt.Errorf("unknown model_version %d", modelVersion)
+			}
+		}
+		return modelScorer{model: model}, nil
+	}
+	if modelVersion != 0 {
+		return nil, fmt.Errorf("model_version only applies to the %s instrument", defaultInstrument)
+	}
+	scorer, ok := instruments[instrument]
+	if !ok {
+		return nil, fmt.Errorf("unknown instrument %q", instrument)
+	}
+	return scorer, nil
+}
+
+// Validated answers with their total and per-question contributions
+type itemScores struct {
+	Total      int
+	Breakdown  []Contribution
+	TopFactors []string
+}
+
+// Validate answers and score each one. Scoring stops at any invalid answer, since a partial
+// or malformed answer set would understate the risk.
+func scoreItems(items []Item, answers map[int]int) (itemScores, error) {
+	if err := validateAnswers(items, answers); err != nil {
+		return itemScores{}, err
+	}
+
+	scores := itemScores{Breakdown: []Contribution{}, TopFactors: []string{}}
+	type factor struct {
+		name  string
+		added int // Points above the item's lowest option
+	}
+	factors := []factor{}
+	for _, item := range items {
+		answer, ok := answers[item.ID]
+		if !ok {
+			continue
+		}
+		option, _ := item.Option(answer)
+		minPoints, maxPoints := option.Points, option.Points
+		for _, o := range item.Options {
+			minPoints = min(minPoints, o.Points)
+			maxPoints = max(maxPoints, o.Points)
+		}
+		scores.Total += option.Points
+		scores.Breakdown = append(scores.Breakdown, Contribution{
+			QuestionID: item.ID,
+			Factor:     item.Factor,
+			Answer:     answer,
+			Points:     option.Points,
+			MaxPoints:  maxPoints,
+		})
+		if option.Points > minPoints {
+			factors = append(factors, factor{item.Factor, option.Points - minPoints})
+		}
+	}
+
+	// Stable sort keeps question order between factors that added the same points
+	sort.SliceStable(factors, func(i, j int) bool { return factors[i].added > factors[j].added })
+	for i := 0; i < len(factors) && i < topFactorCount; i++ {
+		scores.TopFactors = append(scores.TopFactors, factors[i].name)
+	}
+	return scores, nil
+}
+
+// Yes/no options scored only for "yes"
+func yesNo(yesPoints int) []Option {
+	return []Option{{Index: 1, Label: "Yes", Points: yesPoints}, {Index: 2, Label: "No", Points: 0}}
+}
+
+// Scorer for the configurable lb-10 questionnaire, backed by a published risk model
+type modelScorer struct {
+	model *RiskModel
+}
+
+func (s modelScorer) ID() string   { return defaultInstrument }
+func (s modelScorer) Name() string { return "Lions Befrienders fall risk questionnaire" }
+
+func (s modelScorer) Items() []Item {
+	items := make([]Item, 0, len(s.model.Questions))
+	for _, q := range s.model.Questions {
+		item := Item{ID: q.ID, Text: q.Label, Factor: strings.ToLower(q.Label), Optional: q.Optional}
+		for index, points := range q.Points {
+			item.Options = append(item.Options, Option{Index: index, Points: points})
+		}
+		sort.Slice(item.Options, func(i, j int) bool { return item.Options[i].Index < item.Options[j].Index })
+		items = append(items, item)
+	}
+	return items
+}
+
+func (s modelScorer) Score(answers map[int]int) (ScoreResult, error) {
+	scores, err := scoreItems(s.Items(), answers)
+	if err != nil {
+		return ScoreResult{}, err
+	}
+
+	riskLevel := s.model.Level(scores.Total)
+	return ScoreResult{
+		Instrument:     defaultInstrument,
+		TotalScore:     scores.Total,
+		RiskLevel:      riskLevel,
+		Recommendation: s.model.Recommendations[riskLevel],
+		ModelVersion:   s.model.Version,
+		Breakdown:      scores.Breakdown,
+		TopFactors:     scores.TopFactors,
+	}, nil
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+// Answers in item order: the first option index is for item 1, the next for item 2, and so on
+func answersFor(options ...int) map[int]int {
+	answers := make(map[int]int, len(options))
+	for i, option := range options {
+		answers[i+1] = option
+	}
+	return answers
+}
+
+// One scoring case: the answers and the expected total and risk level
+type scoreCase struct {
+	name      string
+	answers   map[int]int
+	wantTotal int
+	wantLevel string
+}
+
+func runScoreCases(t *testing.T, scorer Scorer, cases []scoreCase) {
+	t.Helper()
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := scorer.Score(tt.answers)
+			if err != nil {
+				t.Fatalf("Score returned error: %v", err)
+			}
+			if result.TotalScore != tt.wantTotal || result.RiskLevel != tt.wantLevel {
+				t.Errorf("Score = %d %s, want %d %s", result.TotalScore, result.RiskLevel, tt.wantTotal, tt.wantLevel)
+			}
+			if result.Instrument != scorer.ID() {
+				t.Errorf("Instrument = %q, want %q", result.Instrument, scorer.ID())
+			}
+		})
+	}
+}
+
+// One rejected answer set and the problem expected for one question
+type rejectCase struct {
+	name         string
+	answers      map[int]int
+	wantQuestion int
+	wantCode     string
+}
+
+func runRejectCases(t *testing.T, scorer Scorer, cases []rejectCase) {
+	t.Helper()
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := scorer.Score(tt.answers)
+			var validationErr *ValidationError
+			if !errors.As(err, &validationErr) {
+				t.Fatalf("Score error = %v, want a ValidationError", err)
+			}
+			if len(validationErr.Errors) != 1 {
+				t.Fatalf("got %d problems, want 1: %+v", len(validationErr.Errors), validationErr.Errors)
+			}
+			problem := validationErr.Errors[0]
+			if problem.QuestionID != tt.wantQuestion || problem.Code != tt.wantCode {
+				t.Errorf("problem = question %d %s, want question %d %s", problem.QuestionID, problem.Code, tt.wantQuestion, tt.wantCode)
+			}
+		})
+	}
+}
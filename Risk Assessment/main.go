@@ -2,8 +2,13 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"log"
 	"net/http"
+	"os"
+	"strconv"
+
+	"auth"
 
 	"github.com/gorilla/mux"
 	"github.com/rs/cors"
@@ -18,11 +23,57 @@ func main() {
 		}
 	*/
 
+	// Load the published scoring models
+	modelDir := os.Getenv("RISK_MODEL_DIR")
+	if modelDir == "" {
+		modelDir = "models"
+	}
+	store, err := LoadModelStore(modelDir)
+	if err != nil {
+		log.Fatalf("Failed to load risk models: %v", err)
+	}
+	log.Printf("Loaded risk model version %d", store.Active().Version)
+
+	verifier := &auth.Verifier{Secret: auth.SecretFromEnv(), CareTeamURL: "http://localhost:5004"}
+
 	// Initialize the router
 	router := mux.NewRouter()
 	router.HandleFunc("/api/analyzeRisk", func(w http.ResponseWriter, r *http.Request) {
-		analyzeRiskHandler(w, r)
+		analyzeRiskHandler(w, r, store)
 	}).Methods("POST")
+	router.HandleFunc("/api/analyzeRisk/batch", verifier.RequirePermission(auth.PermScoreInBatch, func(w http.ResponseWriter, r *http.Request) {
+		batchAnalyzeHandler(w, r, store)
+	})).Methods("POST")
+	router.HandleFunc("/api/analyzeRisk/whatIf", verifier.RequirePermission(auth.PermExploreRisk, func(w http.ResponseWriter, r *http.Request) {
+		whatIfHandler(w, r, store)
+	})).Methods("POST")
+
+	// Composite score from the questionnaire, vision test and age
+	router.HandleFunc("/api/compositeRisk", verifier.Require(func(w http.ResponseWriter, r *http.Request) {
+		compositeRiskHandler(w, r, store)
+	})).Methods("POST")
+
+	// Scoring instruments
+	router.HandleFunc("/api/instruments", func(w http.ResponseWriter, r *http.Request) {
+		listInstrumentsHandler(w, r, store)
+	}).Methods("GET")
+	router.HandleFunc("/api/instruments/{id}", func(w http.ResponseWriter, r *http.Request) {
+		getInstrumentHandler(w, r, store)
+	}).Methods("GET")
+
+	// Scoring model versions
+	router.HandleFunc("/api/riskModels", func(w http.ResponseWriter, r *http.Request) {
+		listModelsHandler(w, r, store)
+	}).Methods("GET")
+	router.HandleFunc("/api/riskModels/active", func(w http.ResponseWriter, r *http.Request) {
+		getModelHandler(w, r, store)
+	}).Methods("GET")
+	router.HandleFunc("/api/riskModels/{version}", func(w http.ResponseWriter, r *http.Request) {
+		getModelHandler(w, r, store)
+	}).Methods("GET")
+	router.HandleFunc("/api/riskModels", verifier.RequirePermission(auth.PermManageRiskModels, func(w http.ResponseWriter, r *http.Request) {
+		publishModelHandler(w, r, store)
+	})).Methods("POST")
 
 	// Enable CORS
 	corsHandler := cors.New(cors.Options{
@@ -36,28 +87,67 @@ func main() {
 	log.Fatal(http.ListenAndServe(":8080", corsHandler.Handler(router)))
 }
 
-// Get recommendations based on risk level
-func getRecommendation(riskLevel string) string {
-	switch riskLevel {
-	case "Low":
-		return "Maintain a healthy lifestyle with balance exercises and check-ups."
-	case "Moderate":
-		return "Consider physical therapy, improve home safety, and monitor medications."
-	case "High":
-		return "Consult a healthcare provider for a fall risk assessment and use mobility aids."
-	default:
-		return "No recommendation available."
+// Answers to score and how to score them
+type AnalyzeRequest struct {
+	UserID       int         `json:"user_id"`
+	Instrument   string      `json:"instrument"`    // Optional, defaults to lb-10
+	Answers      map[int]int `json:"answers"`       // {question_id: selected_option_index (1-based)}
+	ModelVersion int         `json:"model_version"` // Optional, lb-10 only, defaults to the active model
+	Language     string      `json:"language"`      // Optional language for advice, defaults to English
+}
+
+// Score one answer set. Incomplete or invalid answers return a *ValidationError listing the
+// problem with each question; other errors describe what is wrong with the request.
+func analyze(store *ModelStore, req AnalyzeRequest) (ScoreResult, error) {
+	// Validate User ID
+	if req.UserID <= 0 {
+		return ScoreResult{}, errors.New("Invalid or missing user_id")
+	}
+
+	// Ensure at least some answers are provided
+	if len(req.Answers) == 0 {
+		return ScoreResult{}, errors.New("No answers provided")
+	}
+
+	if req.Language == "" {
+		req.Language = "English"
+	}
+	if !supportedLanguages[req.Language] {
+		return ScoreResult{}, errors.New("Unsupported language")
+	}
+
+	scorer, err := lookupScorer(req.Instrument, store, req.ModelVersion)
+	if err != nil {
+		return ScoreResult{}, err
+	}
+
+	result, err := scorer.Score(req.Answers)
+	if err != nil {
+		return ScoreResult{}, err
 	}
+	result.Advice = adviceFor(result.Instrument, req.Answers, req.Language)
+	return result, nil
 }
 
-// Analyze risk
-func analyzeRiskHandler(w http.ResponseWriter, r *http.Request) {
-	type Request struct {
-		UserID  int         `json:"user_id"`
-		Answers map[int]int `json:"answers"` // {question_id: selected_option_index (1-based)}
+// Write an error from analyze as a 400 response
+func writeAnalyzeError(w http.ResponseWriter, err error) {
+	// Incomplete or invalid answers are rejected with the problem for each question
+	var invalid *ValidationError
+	if errors.As(err, &invalid) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"message": "Some answers are missing or invalid",
+			"errors":  invalid.Errors,
+		})
+		return
 	}
+	http.Error(w, err.Error(), http.StatusBadRequest)
+}
 
-	var req Request
+// Analyze risk with the requested instrument
+func analyzeRiskHandler(w http.ResponseWriter, r *http.Request, store *ModelStore) {
+	var req AnalyzeRequest
 
 	// Decode JSON request
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -65,67 +155,119 @@ func analyzeRiskHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Validate User ID
-	if req.UserID <= 0 {
-		http.Error(w, "Invalid or missing user_id", http.StatusBadRequest)
+	result, err := analyze(store, req)
+	if err != nil {
+		writeAnalyzeError(w, err)
 		return
 	}
 
-	// Ensure at least some answers are provided
-	if len(req.Answers) == 0 {
-		http.Error(w, "No answers provided", http.StatusBadRequest)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// List the available instruments
+func listInstrumentsHandler(w http.ResponseWriter, r *http.Request, store *ModelStore) {
+	type summary struct {
+		ID    string `json:"id"`
+		Name  string `json:"name"`
+		Items int    `json:"items"`
+	}
+
+	scorers := []Scorer{modelScorer{model: store.Active()}}
+	for _, id := range []string{"steadi", "morse", "short-fes-i"} {
+		scorers = append(scorers, instruments[id])
+	}
+	summaries := []summary{}
+	for _, scorer := range scorers {
+		summaries = append(summaries, summary{ID: scorer.ID(), Name: scorer.Name(), Items: len(scorer.Items())})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(summaries)
+}
+
+// Get an instrument's items with their options and points
+func getInstrumentHandler(w http.ResponseWriter, r *http.Request, store *ModelStore) {
+	scorer, err := lookupScorer(mux.Vars(r)["id"], store, 0)
+	if err != nil {
+		http.Error(w, "Instrument not found", http.StatusNotFound)
 		return
 	}
 
-	// Define risk points (Now indexed from 1)
-	riskPoints := map[int]map[int]int{
-		1:  {1: 2, 2: 0},             // Dizziness: Yes = 2, No = 0
-		2:  {1: 1, 2: 2, 3: 3},       // Balance: Good = 1, Moderate = 2, Poor = 3
-		3:  {1: 1, 2: 2, 3: 3},       // Falls: 0 = 1, 1-2 = 2, 3+ = 3
-		4:  {1: 0, 2: 1, 3: 2, 4: 3}, // Mobility aid
-		5:  {1: 0, 2: 1, 3: 2, 4: 3}, // Unsteady Walking
-		6:  {1: 2, 2: 0},             // Recent fall: Yes = 2, No = 0
-		7:  {1: 0, 2: 2},             // Stand without hands
-		8:  {1: 2, 2: 0, 3: 1},       // Medications
-		9:  {1: 0, 2: 2},             // Exercise
-		10: {1: 2, 2: 0, 3: 1},       // Numbness
-	}
-
-	// Compute total score
-	totalScore := 0
-	for questionID, answerIndex := range req.Answers {
-		if pointsMap, exists := riskPoints[questionID]; exists {
-			if points, valid := pointsMap[answerIndex]; valid {
-				totalScore += points
-			} else {
-				log.Printf("Invalid answer index %d for question %d", answerIndex, questionID)
-			}
-		} else {
-			log.Printf("Invalid question ID: %d", questionID)
-		}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"id":    scorer.ID(),
+		"name":  scorer.Name(),
+		"items": scorer.Items(),
+	})
+}
+
+// List published model versions
+func listModelsHandler(w http.ResponseWriter, r *http.Request, store *ModelStore) {
+	type summary struct {
+		Version     int    `json:"version"`
+		PublishedAt string `json:"published_at"`
+		PublishedBy int    `json:"published_by,omitempty"`
+		Notes       string `json:"notes,omitempty"`
+		Active      bool   `json:"active"`
 	}
 
-	// Determine risk level
-	var riskLevel string
-	switch {
-	case totalScore <= 5:
-		riskLevel = "Low"
-	case totalScore <= 10:
-		riskLevel = "Moderate"
-	default:
-		riskLevel = "High"
+	active := store.Active().Version
+	summaries := []summary{}
+	for _, model := range store.List() {
+		summaries = append(summaries, summary{
+			Version:     model.Version,
+			PublishedAt: model.PublishedAt.Format("2006-01-02 15:04:05"),
+			PublishedBy: model.PublishedBy,
+			Notes:       model.Notes,
+			Active:      model.Version == active,
+		})
 	}
 
-	// Generate recommendation
-	recommendation := getRecommendation(riskLevel)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(summaries)
+}
+
+// Get the full definition of the active model or of a specific version
+func getModelHandler(w http.ResponseWriter, r *http.Request, store *ModelStore) {
+	model := store.Active()
+	if param, ok := mux.Vars(r)["version"]; ok {
+		version, err := strconv.Atoi(param)
+		if err != nil {
+			http.Error(w, "Invalid model version", http.StatusBadRequest)
+			return
+		}
+		if model, ok = store.Get(version); !ok {
+			http.Error(w, "Model version not found", http.StatusNotFound)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(model)
+}
 
-	// Send JSON response
-	response := map[string]interface{}{
-		"total_score":    totalScore,
-		"risk_level":     riskLevel,
-		"recommendation": recommendation,
+// Publish a new model version, which becomes active immediately. Admin only.
+func publishModelHandler(w http.ResponseWriter, r *http.Request, store *ModelStore) {
+	var model RiskModel
+	if err := json.NewDecoder(r.Body).Decode(&model); err != nil {
+		http.Error(w, "Invalid JSON request", http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	caller, _ := auth.CallerFromContext(r.Context())
+	published, err := store.Publish(model, caller.ID)
+	if err != nil {
+		log.Println("Failed to publish risk model:", err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"message": err.Error()})
+		return
 	}
 
+	log.Printf("Risk model version %d published by %d", published.Version, caller.ID)
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(response)
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(published)
 }
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Scoring rules for one question: points awarded for each option (1-based option index).
+// Questions must be answered unless marked optional.
+type QuestionRule struct {
+	ID       int         `json:"id"`
+	Label    string      `json:"label"`
+	Optional bool        `json:"optional,omitempty"`
+	Points   map[int]int `json:"points"`
+}
+
+// Risk band covering scores up to MaxScore. The last band has no maximum.
+type Band struct {
+	Level    string `json:"level"`
+	MaxScore *int   `json:"max_score,omitempty"`
+}
+
+// Versioned scoring model. Versions are never edited once published; a change is a new version.
+type RiskModel struct {
+	Version         int               `json:"version"`
+	PublishedAt     time.Time         `json:"published_at"`
+	PublishedBy     int               `json:"published_by,omitempty"`
+	Notes           string            `json:"notes,omitempty"`
+	Questions       []QuestionRule    `json:"questions"`
+	Bands           []Band            `json:"bands"`
+	Recommendations map[string]string `json:"recommendations"`
+}
+
+// Check that a model is complete and internally consistent before it is published
+func (m *RiskModel) Validate() error {
+	if len(m.Questions) == 0 {
+		return errors.New("model has no questions")
+	}
+	seen := map[int]bool{}
+	for _, q := range m.Questions {
+		if q.ID <= 0 {
+			return fmt.Errorf("question ID %d must be positive", q.ID)
+		}
+		if seen[q.ID] {
+			return fmt.Errorf("question %d is defined more than once", q.ID)
+		}
+		seen[q.ID] = true
+		if len(q.Points) == 0 {
+			return fmt.Errorf("question %d has no options", q.ID)
+		}
+		for option, points := range q.Points {
+			if option <= 0 {
+				return fmt.Errorf("question %d has option index %d; options are 1-based", q.ID, option)
+			}
+			if points < 0 {
+				return fmt.Errorf("question %d option %d has negative points", q.ID, option)
+			}
+		}
+	}
+
+	if len(m.Bands) == 0 {
+		return errors.New("model has no risk bands")
+	}
+	previous := -1
+	for i, b := range m.Bands {
+		if b.Level == "" {
+			return fmt.Errorf("band %d has no level", i+1)
+		}
+		last := i == len(m.Bands)-1
+		if last != (b.MaxScore == nil) {
+			return errors.New("every band except the last needs a max_score, and the last must not have one")
+		}
+		if b.MaxScore != nil {
+			if *b.MaxScore <= previous {
+				return errors.New("band max_score values must increase")
+			}
+			previous = *b.MaxScore
+		}
+		if m.Recommendations[b.Level] == "" {
+			return fmt.Errorf("no recommendation for band %s", b.Level)
+		}
+	}
+	return nil
+}
+
+// Points rules for a question, if the model has one
+func (m *RiskModel) Question(id int) (QuestionRule, bool) {
+	for _, q := range m.Questions {
+		if q.ID == id {
+			return q, true
+		}
+	}
+	return QuestionRule{}, false
+}
+
+// Highest total score the model can give
+func (m *RiskModel) MaxScore() int {
+	total := 0
+	for _, q := range m.Questions {
+		highest := 0
+		for _, points := range q.Points {
+			highest = max(highest, points)
+		}
+		total += highest
+	}
+	return total
+}
+
+// Band a total score falls into
+func (m *RiskModel) Level(totalScore int) string {
+	for _, b := range m.Bands {
+		if b.MaxScore == nil || totalScore <= *b.MaxScore {
+			return b.Level
+		}
+	}
+	return m.Bands[len(m.Bands)-1].Level
+}
+
+var modelFileRegex = regexp.MustCompile(`^v([0-9]+)\.json$`)
+
+// ModelStore keeps every published model version, persisted as v<N>.json files in Dir.
+// The highest version is the active one.
+type ModelStore struct {
+	Dir string
+
+	mu     sync.RWMutex
+	models map[int]*RiskModel
+	active *RiskModel
+}
+
+// Load all model versions from dir
+func LoadModelStore(dir string) (*ModelStore, error) {
+	store := &ModelStore{Dir: dir, models: map[int]*RiskModel{}}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		match := modelFileRegex.FindStringSubmatch(entry.Name())
+		if match == nil {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		var model RiskModel
+		if err := json.Unmarshal(data, &model); err != nil {
+			return nil, fmt.Errorf("%s: %w", entry.Name(), err)
+		}
+		if version, _ := strconv.Atoi(match[1]); model.Version != version {
+			return nil, fmt.Errorf("%s: file declares version %d", entry.Name(), model.Version)
+		}
+		if err := model.Validate(); err != nil {
+			return nil, fmt.Errorf("%s: %w", entry.Name(), err)
+		}
+		store.models[model.Version] = &model
+		if store.active == nil || model.Version > store.active.Version {
+			store.active = &model
+		}
+	}
+
+	if store.active == nil {
+		return nil, fmt.Errorf("no risk models found in %s", dir)
+	}
+	return store, nil
+}
+
+// Currently active model
+func (s *ModelStore) Active() *RiskModel {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.active
+}
+
+// A specific model version
+func (s *ModelStore) Get(version int) (*RiskModel, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	model, ok := s.models[version]
+	return model, ok
+}
+
+// All published versions, oldest first
+func (s *ModelStore) List() []*RiskModel {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	models := make([]*RiskModel, 0, len(s.models))
+	for _, model := range s.models {
+		models = append(models, model)
+	}
+	sort.Slice(models, func(i, j int) bool { return models[i].Version < models[j].Version })
+	return models
+}
+
+// Validate a new model, assign it the next version number, write it to disk and make it active
+func (s *ModelStore) Publish(model RiskModel, publishedBy int) (*RiskModel, error) {
+	if err := model.Validate(); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	model.Version = s.active.Version + 1
+	model.PublishedAt = time.Now().UTC()
+	model.PublishedBy = publishedBy
+
+	data, err := json.MarshalIndent(model, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	// Write to a temporary file first so a crash never leaves a half-written version behind
+	path := filepath.Join(s.Dir, fmt.Sprintf("v%d.json", model.Version))
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return nil, err
+	}
+
+	s.models[model.Version] = &model
+	s.active = &model
+	return &model, nil
+}
@@ -0,0 +1,59 @@
+package main
+
+// Morse Fall Scale: six items scored by a nurse or clinician, 0 to 125 points.
+// Bands follow the commonly used cut-offs: 0-24 low, 25-44 moderate, 45 and above high.
+type morseScorer struct{}
+
+var morseItems = []Item{
+	{ID: 1, Factor: "prior falls", Text: "History of falling (immediate or within 3 months)", Options: []Option{
+		{Index: 1, Label: "No", Points: 0},
+		{Index: 2, Label: "Yes", Points: 25},
+	}},
+	{ID: 2, Factor: "secondary diagnosis", Text: "Secondary diagnosis", Options: []Option{
+		{Index: 1, Label: "No", Points: 0},
+		{Index: 2, Label: "Yes", Points: 15},
+	}},
+	{ID: 3, Factor: "ambulatory aid", Text: "Ambulatory aid", Options: []Option{
+		{Index: 1, Label: "None / bed rest / nurse assist", Points: 0},
+		{Index: 2, Label: "Crutches / cane / walker", Points: 15},
+		{Index: 3, Label: "Furniture", Points: 30},
+	}},
+	{ID: 4, Factor: "IV therapy", Text: "IV therapy or heparin lock", Options: []Option{
+		{Index: 1, Label: "No", Points: 0},
+		{Index: 2, Label: "Yes", Points: 20},
+	}},
+	{ID: 5, Factor: "gait", Text: "Gait", Options: []Option{
+		{Index: 1, Label: "Normal / bed rest / wheelchair", Points: 0},
+		{Index: 2, Label: "Weak", Points: 10},
+		{Index: 3, Label: "Impaired", Points: 20},
+	}},
+	{ID: 6, Factor: "mental status", Text: "Mental status", Options: []Option{
+		{Index: 1, Label: "Oriented to own ability", Points: 0},
+		{Index: 2, Label: "Overestimates or forgets limitations", Points: 15},
+	}},
+}
+
+func (morseScorer) ID() string    { return "morse" }
+func (morseScorer) Name() string  { return "Morse Fall Scale" }
+func (morseScorer) Items() []Item { return morseItems }
+
+func (s morseScorer) Score(answers map[int]int) (ScoreResult, error) {
+	scores, err := scoreItems(morseItems, answers)
+	if err != nil {
+		return ScoreResult{}, err
+	}
+
+	result := ScoreResult{Instrument: s.ID(), TotalScore: scores.Total, Breakdown: scores.Breakdown, TopFactors: scores.TopFactors}
+	switch {
+	case scores.Total <= 24:
+		result.RiskLevel = "Low"
+		result.Recommendation = "Good basic nursing care and standard fall precautions."
+	case scores.Total <= 44:
+		result.RiskLevel = "Moderate"
+		result.Recommendation = "Implement standard fall prevention interventions."
+	default:
+		result.RiskLevel = "High"
+		result.Recommendation = "Implement high-risk fall prevention interventions."
+	}
+	return result, nil
+}
@@ -0,0 +1,29 @@
+package main
+
+import "testing"
+
+// Options are in the order listed in morse.go, starting at 1 for the lowest score
+func TestMorseScore(t *testing.T) {
+	runScoreCases(t, morseScorer{}, []scoreCase{
+		{"no risk factors", answersFor(1, 1, 1, 1, 1, 1), 0, "Low"},
+		{"highest score on every item", answersFor(2, 2, 3, 2, 3, 2), 125, "High"},
+		{"falls, secondary diagnosis, crutches, IV and weak gait", answersFor(2, 2, 2, 2, 2, 1), 85, "High"},
+		{"20 points is low", answersFor(1, 1, 1, 2, 1, 1), 20, "Low"},
+		{"25 points is moderate", answersFor(2, 1, 1, 1, 1, 1), 25, "Moderate"},
+		{"40 points is moderate", answersFor(2, 2, 1, 1, 1, 1), 40, "Moderate"},
+		{"45 points is high", answersFor(2, 1, 1, 2, 1, 1), 45, "High"},
+	})
+}
+
+func TestMorseRejectsInvalidAnswers(t *testing.T) {
+	missing := answersFor(1, 1, 1, 1, 1, 1)
+	delete(missing, 5)
+
+	runRejectCases(t, morseScorer{}, []rejectCase{
+		{"option 0", answersFor(0, 1, 1, 1, 1, 1), 1, answerInvalidOption},
+		{"option 3 on a yes/no item", answersFor(1, 1, 1, 3, 1, 1), 4, answerInvalidOption},
+		{"option 4 for ambulatory aid", answersFor(1, 1, 4, 1, 1, 1), 3, answerInvalidOption},
+		{"unanswered gait", missing, 5, answerMissing},
+		{"item 7", answersFor(1, 1, 1, 1, 1, 1, 1), 7, answerUnknownQuestion},
+	})
+}
@@ -0,0 +1,47 @@
+package main
+
+// CDC STEADI "Stay Independent" brochure: 12 yes/no statements, 14 points maximum.
+// A total of 4 or more means the person may be at risk for falling and should be assessed.
+type steadiScorer struct{}
+
+var steadiItems = []Item{
+	{ID: 1, Factor: "prior falls", Text: "I have fallen in the past year.", Options: yesNo(2)},
+	{ID: 2, Factor: "walking aid", Text: "I use or have been advised to use a cane or walker to get around safely.", Options: yesNo(2)},
+	{ID: 3, Factor: "unsteady walking", Text: "Sometimes I feel unsteady when I am walking.", Options: yesNo(1)},
+	{ID: 4, Factor: "holding onto furniture", Text: "I steady myself by holding onto furniture when walking at home.", Options: yesNo(1)},
+	{ID: 5, Factor: "fear of falling", Text: "I am worried about falling.", Options: yesNo(1)},
+	{ID: 6, Factor: "leg weakness", Text: "I need to push with my hands to stand up from a chair.", Options: yesNo(1)},
+	{ID: 7, Factor: "trouble with curbs", Text: "I have some trouble stepping up onto a curb.", Options: yesNo(1)},
+	{ID: 8, Factor: "urinary urgency", Text: "I often have to rush to the toilet.", Options: yesNo(1)},
+	{ID: 9, Factor: "foot numbness", Text: "I have lost some feeling in my feet.", Options: yesNo(1)},
+	{ID: 10, Factor: "medication side effects", Text: "I take medicine that sometimes makes me feel light-headed or more tired than usual.", Options: yesNo(1)},
+	{ID: 11, Factor: "sleep or mood medication", Text: "I take medicine to help me sleep or improve my mood.", Options: yesNo(1)},
+	{ID: 12, Factor: "low mood", Text: "I often feel sad or depressed.", Options: yesNo(1)},
+}
+
+func (steadiScorer) ID() string    { return "steadi" }
+func (steadiScorer) Name() string  { return "CDC STEADI Stay Independent" }
+func (steadiScorer) Items() []Item { return steadiItems }
+
+func (s steadiScorer) Score(answers map[int]int) (ScoreResult, error) {
+	scores, err := scoreItems(steadiItems, answers)
+	if err != nil {
+		return ScoreResult{}, err
+	}
+
+	// The screener only separates "at risk" from "not at risk". A positive screen is reported
+	// as High so the care team is alerted to follow up with a full assessment.
+	result := ScoreResult{
+		Instrument:     s.ID(),
+		TotalScore:     scores.Total,
+		RiskLevel:      "Low",
+		Recommendation: "Your answers do not suggest a raised fall risk. Stay active and repeat this check every year.",
+		Breakdown:      scores.Breakdown,
+		TopFactors:     scores.TopFactors,
+	}
+	if scores.Total >= 4 {
+		result.RiskLevel = "High"
+		result.Recommendation = "You may be at risk for falling. Talk to your doctor about a fall risk assessment and ways to prevent falls."
+	}
+	return result, nil
+}
@@ -0,0 +1,28 @@
+package main
+
+import "testing"
+
+// Options: 1 = Yes, 2 = No
+func TestSteadiScore(t *testing.T) {
+	runScoreCases(t, steadiScorer{}, []scoreCase{
+		{"all no", answersFor(2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2), 0, "Low"},
+		{"all yes scores the 14 point maximum", answersFor(1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1), 14, "High"},
+		{"a fall, worry about falling and pushing up from a chair", answersFor(1, 2, 2, 2, 1, 1, 2, 2, 2, 2, 2, 2), 4, "High"},
+		{"3 points is below the cut-off", answersFor(1, 2, 1, 2, 2, 2, 2, 2, 2, 2, 2, 2), 3, "Low"},
+		{"4 points is at risk", answersFor(2, 2, 1, 1, 1, 1, 2, 2, 2, 2, 2, 2), 4, "High"},
+		{"falls and walking aid alone are at risk", answersFor(1, 1, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2), 4, "High"},
+	})
+}
+
+func TestSteadiRejectsInvalidAnswers(t *testing.T) {
+	missing := answersFor(2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2)
+	delete(missing, 12)
+	extra := answersFor(2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 1)
+
+	runRejectCases(t, steadiScorer{}, []rejectCase{
+		{"option 0", answersFor(0, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2), 1, answerInvalidOption},
+		{"option 3", answersFor(2, 2, 2, 2, 2, 3, 2, 2, 2, 2, 2, 2), 6, answerInvalidOption},
+		{"unanswered statement", missing, 12, answerMissing},
+		{"statement 13", extra, 13, answerUnknownQuestion},
+	})
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Answer problem codes
+const (
+	answerMissing         = "missing"
+	answerInvalidOption   = "invalid_option"
+	answerUnknownQuestion = "unknown_question"
+)
+
+// Problem with the answer to one question
+type AnswerError struct {
+	QuestionID int    `json:"question_id"`
+	Code       string `json:"code"`
+	Message    string `json:"message"`
+}
+
+// ValidationError lists every problem with a submitted answer set, ordered by question ID
+type ValidationError struct {
+	Errors []AnswerError `json:"errors"`
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("%d invalid answers", len(e.Errors))
+}
+
+// Check answers against an instrument's items: every required item is answered, each answer is
+// one of the item's options, and there are no answers to items the instrument doesn't have
+func validateAnswers(items []Item, answers map[int]int) error {
+	problems := []AnswerError{}
+	known := map[int]bool{}
+	for _, item := range items {
+		known[item.ID] = true
+		answer, ok := answers[item.ID]
+		if !ok {
+			if !item.Optional {
+				problems = append(problems, AnswerError{item.ID, answerMissing, fmt.Sprintf("Question %d is required", item.ID)})
+			}
+			continue
+		}
+		if _, ok := item.Option(answer); !ok {
+			problems = append(problems, AnswerError{item.ID, answerInvalidOption,
+				fmt.Sprintf("Option %d is not valid for question %d (choose 1 to %d)", answer, item.ID, len(item.Options))})
+		}
+	}
+	for questionID := range answers {
+		if !known[questionID] {
+			problems = append(problems, AnswerError{questionID, answerUnknownQuestion, fmt.Sprintf("Question %d is not part of this questionnaire", questionID)})
+		}
+	}
+
+	if len(problems) == 0 {
+		return nil
+	}
+	sort.Slice(problems, func(i, j int) bool { return problems[i].QuestionID < problems[j].QuestionID })
+	return &ValidationError{Errors: problems}
+}
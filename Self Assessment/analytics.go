@@ -0,0 +1,449 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"auth"
+)
+
+const patientAgesURL = "http://localhost:5001/api/getPatientAges"
+
+// Age bands for cohort analytics, by lower bound in years
+var ageBands = []struct {
+	label string
+	from  int
+}{
+	{"under 60", 0},
+	{"60-69", 60},
+	{"70-79", 70},
+	{"80-89", 80},
+	{"90+", 90},
+}
+
+// How many questions to list in the most frequently high-scoring questions
+const topQuestionCount = 5
+
+// Assessments aggregated over one group of a dimension, e.g. one month or one age band
+type cohortGroup struct {
+	Group        string         `json:"group"`
+	Assessments  int            `json:"assessments"`
+	Patients     int            `json:"patients"`
+	RiskLevels   map[string]int `json:"risk_levels"`
+	AverageScore float64        `json:"average_score"`
+
+	patients map[int]bool
+	scoreSum int
+}
+
+// A question and how often its answer scored the question's maximum points
+type highScoringQuestion struct {
+	QuestionID  int     `json:"question_id"`
+	Factor      string  `json:"factor"`
+	HighScoring int     `json:"high_scoring"` // Assessments where the answer scored the maximum
+	Answered    int     `json:"answered"`
+	Share       float64 `json:"share"`
+}
+
+// Patients whose risk level went up between their first and last assessment in the period
+type riskChange struct {
+	Patients      int     `json:"patients"` // With at least two assessments in the period
+	Increased     int     `json:"increased"`
+	Decreased     int     `json:"decreased"`
+	Unchanged     int     `json:"unchanged"`
+	IncreaseShare float64 `json:"increase_share"`
+}
+
+// One assessment as used by the analytics
+type cohortAssessment struct {
+	UserID     int
+	Date       time.Time
+	TotalScore int
+	RiskLevel  string
+	Language   string
+	Breakdown  []struct {
+		QuestionID int    `json:"question_id"`
+		Factor     string `json:"factor"`
+		Points     int    `json:"points"`
+		MaxPoints  int    `json:"max_points"`
+	}
+}
+
+// Groups of one dimension in first-seen order
+type cohortDimension struct {
+	groups []*cohortGroup
+	byName map[string]*cohortGroup
+}
+
+func (d *cohortDimension) add(name string, a cohortAssessment) {
+	if d.byName == nil {
+		d.byName = map[string]*cohortGroup{}
+	}
+	group, ok := d.byName[name]
+	if !ok {
+		group = &cohortGroup{Group: name, RiskLevels: map[string]int{"Low": 0, "Moderate": 0, "High": 0}, patients: map[int]bool{}}
+		d.byName[name] = group
+		d.groups = append(d.groups, group)
+	}
+	group.Assessments++
+	group.patients[a.UserID] = true
+	group.Patients = len(group.patients)
+	if a.RiskLevel != "" {
+		group.RiskLevels[a.RiskLevel]++
+	}
+	group.scoreSum += a.TotalScore
+	group.AverageScore = roundTo2(float64(group.scoreSum) / float64(group.Assessments))
+}
+
+func roundTo2(value float64) float64 {
+	return math.Round(value*100) / 100
+}
+
+func ageBand(age int, known bool) string {
+	if !known {
+		return "unknown"
+	}
+	band := ageBands[0].label
+	for _, b := range ageBands {
+		if age >= b.from {
+			band = b.label
+		}
+	}
+	return band
+}
+
+// Period an assessment falls in: "2025-03" by month or "2025-W10" by ISO week
+func periodOf(date time.Time, window string) string {
+	if window == "week" {
+		year, week := date.ISOWeek()
+		return fmt.Sprintf("%d-W%02d", year, week)
+	}
+	return date.Format("2006-01")
+}
+
+// Load the assessments of the given patients in [from, to), oldest first
+func loadCohortAssessments(db *sql.DB, patientIDs []int, from, to time.Time) ([]cohortAssessment, error) {
+	if len(patientIDs) == 0 {
+		return nil, nil
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(patientIDs)), ", ")
+	query := `SELECT a.UserID, a.DateCreated, IFNULL(a.TotalScore, 0), IFNULL(a.RiskLevel, ''), IFNULL(a.ScoreBreakdown, ''),
+                     IFNULL((SELECT MAX(x.Language) FROM AssessmentAnswers x WHERE x.AssessmentID = a.AssessmentID), '')
+              FROM Assessments a
+              WHERE a.UserID IN (` + placeholders + `) AND a.DateCreated >= ? AND a.DateCreated < ?
+              ORDER BY a.DateCreated, a.AssessmentID`
+	args := []interface{}{}
+	for _, id := range patientIDs {
+		args = append(args, id)
+	}
+	args = append(args, from, to)
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	assessments := []cohortAssessment{}
+	for rows.Next() {
+		var a cohortAssessment
+		var breakdown string
+		if err := rows.Scan(&a.UserID, &a.Date, &a.TotalScore, &a.RiskLevel, &breakdown, &a.Language); err != nil {
+			return nil, err
+		}
+		if breakdown != "" {
+			json.Unmarshal([]byte(breakdown), &a.Breakdown) // Older assessments have no breakdown
+		}
+		assessments = append(assessments, a)
+	}
+	return assessments, rows.Err()
+}
+
+// Ask the User service for the patients' ages, with the caller's token so care-team rules apply
+func fetchPatientAges(token string, patientIDs []int) (map[int]int, error) {
+	body, _ := json.Marshal(map[string][]int{"user_ids": patientIDs})
+	req, err := http.NewRequest(http.MethodPost, patientAgesURL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("patient ages returned status %d", resp.StatusCode)
+	}
+	var result struct {
+		Ages map[int]int `json:"ages"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, err
+	}
+	return result.Ages, nil
+}
+
+// Population view over the assessments of the caller's patients: counts by risk band, period, age
+// band and language, the questions that most often score their maximum, and how many patients'
+// risk rose. Query parameters, all optional:
+//
+//	from, to   date range, YYYY-MM-DD (to is inclusive; default: everything)
+//	window     month (default) or week, for the by_period groups
+//	format     json (default) or csv
+func cohortAnalyticsHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
+	query := r.URL.Query()
+	from, to := time.Time{}, time.Now().AddDate(0, 0, 1)
+	var err error
+	if value := query.Get("from"); value != "" {
+		if from, err = time.Parse("2006-01-02", value); err != nil {
+			http.Error(w, "from must be a date in YYYY-MM-DD format", http.StatusBadRequest)
+			return
+		}
+	}
+	if value := query.Get("to"); value != "" {
+		if to, err = time.Parse("2006-01-02", value); err != nil {
+			http.Error(w, "to must be a date in YYYY-MM-DD format", http.StatusBadRequest)
+			return
+		}
+		to = to.AddDate(0, 0, 1)
+	}
+	if !from.Before(to) {
+		http.Error(w, "from must not be after to", http.StatusBadRequest)
+		return
+	}
+	window := query.Get("window")
+	if window == "" {
+		window = "month"
+	}
+	if window != "month" && window != "week" {
+		http.Error(w, "window must be month or week", http.StatusBadRequest)
+		return
+	}
+	format := query.Get("format")
+	if format == "" {
+		format = "json"
+	}
+	if format != "json" && format != "csv" {
+		http.Error(w, "format must be json or csv", http.StatusBadRequest)
+		return
+	}
+
+	// Only the patients on the caller's care team are included
+	caller, _ := auth.CallerFromContext(r.Context())
+	patientIDs, err := caller.AssignedPatients()
+	if err != nil {
+		log.Println("Care team lookup error:", err)
+		http.Error(w, "Failed to fetch data", http.StatusInternalServerError)
+		return
+	}
+
+	assessments, err := loadCohortAssessments(db, patientIDs, from, to)
+	if err != nil {
+		log.Println("Database query error:", err)
+		http.Error(w, "Failed to fetch data", http.StatusInternalServerError)
+		return
+	}
+
+	ages := map[int]int{}
+	if len(assessments) > 0 {
+		if ages, err = fetchPatientAges(auth.BearerToken(r), patientIDs); err != nil {
+			log.Println("Cohort analytics: patient ages lookup error:", err)
+			http.Error(w, "Failed to fetch patient ages", http.StatusBadGateway)
+			return
+		}
+	}
+
+	var byRiskBand, byPeriod, byAgeBand, byLanguage cohortDimension
+	patients := map[int]bool{}
+	type questionCount struct {
+		factor         string
+		high, answered int
+	}
+	questions := map[int]*questionCount{}
+	first, last := map[int]string{}, map[int]string{}
+	assessmentCount := map[int]int{}
+
+	for _, a := range assessments {
+		patients[a.UserID] = true
+		band := a.RiskLevel
+		if band == "" {
+			band = "unknown"
+		}
+		byRiskBand.add(band, a)
+		byPeriod.add(periodOf(a.Date, window), a)
+		age, known := ages[a.UserID]
+		byAgeBand.add(ageBand(age, known), a)
+		language := a.Language
+		if language == "" {
+			language = "unknown"
+		}
+		byLanguage.add(language, a)
+
+		for _, c := range a.Breakdown {
+			q, ok := questions[c.QuestionID]
+			if !ok {
+				q = &questionCount{factor: c.Factor}
+				questions[c.QuestionID] = q
+			}
+			q.answered++
+			if c.MaxPoints > 0 && c.Points == c.MaxPoints {
+				q.high++
+			}
+		}
+
+		if _, ok := first[a.UserID]; !ok {
+			first[a.UserID] = a.RiskLevel
+		}
+		last[a.UserID] = a.RiskLevel
+		assessmentCount[a.UserID]++
+	}
+
+	topQuestions := []highScoringQuestion{}
+	for id, q := range questions {
+		if q.high == 0 {
+			continue
+		}
+		topQuestions = append(topQuestions, highScoringQuestion{id, q.factor, q.high, q.answered, roundTo2(float64(q.high) / float64(q.answered))})
+	}
+	sort.Slice(topQuestions, func(i, j int) bool {
+		if topQuestions[i].HighScoring != topQuestions[j].HighScoring {
+			return topQuestions[i].HighScoring > topQuestions[j].HighScoring
+		}
+		return topQuestions[i].QuestionID < topQuestions[j].QuestionID
+	})
+	if len(topQuestions) > topQuestionCount {
+		topQuestions = topQuestions[:topQuestionCount]
+	}
+
+	change := riskChange{}
+	for userID, count := range assessmentCount {
+		if count < 2 {
+			continue
+		}
+		change.Patients++
+		switch before, after := riskLevelRank[first[userID]], riskLevelRank[last[userID]]; {
+		case after > before:
+			change.Increased++
+		case after < before:
+			change.Decreased++
+		default:
+			change.Unchanged++
+		}
+	}
+	if change.Patients > 0 {
+		change.IncreaseShare = roundTo2(float64(change.Increased) / float64(change.Patients))
+	}
+
+	// Periods and age bands read best in order; risk bands from Low to High
+	sort.Slice(byPeriod.groups, func(i, j int) bool { return byPeriod.groups[i].Group < byPeriod.groups[j].Group })
+	sort.SliceStable(byAgeBand.groups, func(i, j int) bool {
+		return ageBandIndex(byAgeBand.groups[i].Group) < ageBandIndex(byAgeBand.groups[j].Group)
+	})
+	sort.SliceStable(byRiskBand.groups, func(i, j int) bool {
+		return riskBandIndex(byRiskBand.groups[i].Group) < riskBandIndex(byRiskBand.groups[j].Group)
+	})
+	sort.Slice(byLanguage.groups, func(i, j int) bool { return byLanguage.groups[i].Group < byLanguage.groups[j].Group })
+
+	report := map[string]interface{}{
+		"from":                       from.Format("2006-01-02"),
+		"to":                         to.AddDate(0, 0, -1).Format("2006-01-02"),
+		"window":                     window,
+		"patients":                   len(patients),
+		"assessments":                len(assessments),
+		"by_risk_band":               nonNilGroups(byRiskBand.groups),
+		"by_period":                  nonNilGroups(byPeriod.groups),
+		"by_age_band":                nonNilGroups(byAgeBand.groups),
+		"by_language":                nonNilGroups(byLanguage.groups),
+		"top_high_scoring_questions": topQuestions,
+		"risk_change":                change,
+	}
+	if from.IsZero() {
+		report["from"] = nil
+	}
+
+	if format == "csv" {
+		writeCohortCSV(w, report, map[string][]*cohortGroup{
+			"risk_band": byRiskBand.groups,
+			"period":    byPeriod.groups,
+			"age_band":  byAgeBand.groups,
+			"language":  byLanguage.groups,
+		}, topQuestions, change)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
+
+func nonNilGroups(groups []*cohortGroup) []*cohortGroup {
+	if groups == nil {
+		return []*cohortGroup{}
+	}
+	return groups
+}
+
+func ageBandIndex(label string) int {
+	for i, b := range ageBands {
+		if b.label == label {
+			return i
+		}
+	}
+	return len(ageBands) // unknown last
+}
+
+func riskBandIndex(level string) int {
+	if rank, ok := riskLevelRank[level]; ok {
+		return rank
+	}
+	return len(riskLevelRank) // unknown last
+}
+
+// Write the analytics as one long-format CSV, section,group,metric,value, so every part fits in
+// one sheet and can be pivoted
+func writeCohortCSV(w http.ResponseWriter, report map[string]interface{}, dimensions map[string][]*cohortGroup, topQuestions []highScoringQuestion, change riskChange) {
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", `attachment; filename="cohort_analytics.csv"`)
+
+	out := csv.NewWriter(w)
+	out.Write([]string{"section", "group", "metric", "value"})
+	out.Write([]string{"summary", "all", "patients", strconv.Itoa(report["patients"].(int))})
+	out.Write([]string{"summary", "all", "assessments", strconv.Itoa(report["assessments"].(int))})
+
+	for _, section := range []string{"risk_band", "period", "age_band", "language"} {
+		for _, g := range dimensions[section] {
+			out.Write([]string{section, g.Group, "assessments", strconv.Itoa(g.Assessments)})
+			out.Write([]string{section, g.Group, "patients", strconv.Itoa(g.Patients)})
+			for _, level := range []string{"Low", "Moderate", "High"} {
+				out.Write([]string{section, g.Group, "risk_" + strings.ToLower(level), strconv.Itoa(g.RiskLevels[level])})
+			}
+			out.Write([]string{section, g.Group, "average_score", strconv.FormatFloat(g.AverageScore, 'f', 2, 64)})
+		}
+	}
+
+	for _, q := range topQuestions {
+		group := fmt.Sprintf("question %d (%s)", q.QuestionID, q.Factor)
+		out.Write([]string{"high_scoring_question", group, "high_scoring", strconv.Itoa(q.HighScoring)})
+		out.Write([]string{"high_scoring_question", group, "answered", strconv.Itoa(q.Answered)})
+		out.Write([]string{"high_scoring_question", group, "share", strconv.FormatFloat(q.Share, 'f', 2, 64)})
+	}
+
+	out.Write([]string{"risk_change", "all", "patients", strconv.Itoa(change.Patients)})
+	out.Write([]string{"risk_change", "all", "increased", strconv.Itoa(change.Increased)})
+	out.Write([]string{"risk_change", "all", "decreased", strconv.Itoa(change.Decreased)})
+	out.Write([]string{"risk_change", "all", "unchanged", strconv.Itoa(change.Unchanged)})
+	out.Write([]string{"risk_change", "all", "increase_share", strconv.FormatFloat(change.IncreaseShare, 'f', 2, 64)})
+	out.Flush()
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"regexp"
+	"strings"
+)
+
+// Older rows store answers as `{ 1: 2, 2: 3 }`, with unquoted keys
+var unquotedKeyRegex = regexp.MustCompile(`([{,]\s*)(\d+)\s*:`)
+
+// Parse the legacy answers blob in Assessments.QuestionResponses
+func parseQuestionResponses(stored string) (map[int]int, error) {
+	answers := map[int]int{}
+	if err := json.Unmarshal([]byte(stored), &answers); err == nil {
+		return answers, nil
+	}
+	fixed := unquotedKeyRegex.ReplaceAllString(stored, `$1"$2":`)
+	if err := json.Unmarshal([]byte(fixed), &answers); err != nil {
+		return nil, err
+	}
+	return answers, nil
+}
+
+// Move answers still held in the legacy Assessments.QuestionResponses blob into AssessmentAnswers,
+// clearing the blob once it's copied. Runs at startup; rows whose blob can't be read are left as
+// they are and logged. Their language and question version weren't recorded, so stay NULL.
+func migrateLegacyAnswers(db *sql.DB) error {
+	rows, err := db.Query("SELECT AssessmentID, QuestionResponses FROM Assessments WHERE QuestionResponses IS NOT NULL")
+	if err != nil {
+		return err
+	}
+	type legacyRow struct {
+		assessmentID int
+		stored       string
+	}
+	legacy := []legacyRow{}
+	for rows.Next() {
+		var row legacyRow
+		if err := rows.Scan(&row.assessmentID, &row.stored); err != nil {
+			rows.Close()
+			return err
+		}
+		legacy = append(legacy, row)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	migrated := 0
+	for _, row := range legacy {
+		answers, err := parseQuestionResponses(row.stored)
+		if err != nil {
+			log.Printf("Can't migrate answers for assessment %d: %v\n", row.assessmentID, err)
+			continue
+		}
+
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+		if err := saveAnswers(tx, int64(row.assessmentID), answers, "", nil); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if _, err := tx.Exec("UPDATE Assessments SET QuestionResponses = NULL WHERE AssessmentID = ?", row.assessmentID); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+		migrated++
+	}
+
+	if len(legacy) > 0 {
+		log.Printf("Migrated legacy answers for %d of %d assessments\n", migrated, len(legacy))
+	}
+	return nil
+}
+
+// Store an assessment's answers, with the language they were given in and the version of each
+// question that was answered. Answers already stored for a question are kept.
+func saveAnswers(tx *sql.Tx, assessmentID int64, answers map[int]int, language string, versions map[int]int) error {
+	if len(answers) == 0 {
+		return nil
+	}
+	placeholders := []string{}
+	args := []interface{}{}
+	for questionID, option := range answers {
+		placeholders = append(placeholders, "(?, ?, ?, NULLIF(?, ''), NULLIF(?, 0))")
+		args = append(args, assessmentID, questionID, option, language, versions[questionID])
+	}
+	_, err := tx.Exec("INSERT IGNORE INTO AssessmentAnswers (AssessmentID, QuestionID, OptionIndex, Language, QuestionVersion) VALUES "+
+		strings.Join(placeholders, ", "), args...)
+	return err
+}
+
+// Load the answers of a patient's assessments, or of every assessment when userID is 0, keyed by assessment
+func loadAnswerSets(db *sql.DB, userID int) (map[int]map[int]int, error) {
+	query := `SELECT a.AssessmentID, a.QuestionID, a.OptionIndex
+              FROM AssessmentAnswers a
+              JOIN Assessments s ON s.AssessmentID = a.AssessmentID`
+	args := []interface{}{}
+	if userID > 0 {
+		query += " WHERE s.UserID = ?"
+		args = append(args, userID)
+	}
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sets := map[int]map[int]int{}
+	for rows.Next() {
+		var assessmentID, questionID, option int
+		if err := rows.Scan(&assessmentID, &questionID, &option); err != nil {
+			return nil, err
+		}
+		if sets[assessmentID] == nil {
+			sets[assessmentID] = map[int]int{}
+		}
+		sets[assessmentID][questionID] = option
+	}
+	return sets, rows.Err()
+}
+
+// One answer of an assessment with the question and chosen option as text
+type AnsweredQuestion struct {
+	QuestionID      int    `json:"questionId"`
+	Question        string `json:"question"`
+	Option          int    `json:"option"` // 1-based option index
+	Answer          string `json:"answer"`
+	QuestionVersion int    `json:"questionVersion,omitempty"` // Version answered; unknown for older assessments
+	QuestionChanged bool   `json:"questionChanged,omitempty"` // Question has been reworded since
+}
+
+// Load an assessment's answers in questionnaire order, with text in the given language, or the
+// language the assessment was taken in when language is empty. Falls back to English for
+// questions without a translation.
+func loadAnsweredQuestions(db *sql.DB, assessmentID int, language string) ([]AnsweredQuestion, error) {
+	query := `SELECT a.QuestionID, a.OptionIndex, IFNULL(a.QuestionVersion, 0), IFNULL(q.Version, 0),
+                     IFNULL(t.QuestionContent, e.QuestionContent), IFNULL(t.QuestionOptions, e.QuestionOptions)
+              FROM AssessmentAnswers a
+              LEFT JOIN Questions q ON q.QuestionID = a.QuestionID
+              LEFT JOIN QuestionTranslations e ON e.QuestionID = a.QuestionID AND e.Language = 'English'
+              LEFT JOIN QuestionTranslations t ON t.QuestionID = a.QuestionID AND t.Language = IFNULL(NULLIF(?, ''), IFNULL(a.Language, 'English'))
+              WHERE a.AssessmentID = ?
+              ORDER BY IFNULL(q.DisplayOrder, a.QuestionID), a.QuestionID`
+	rows, err := db.Query(query, language, assessmentID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	answers := []AnsweredQuestion{}
+	for rows.Next() {
+		var a AnsweredQuestion
+		var currentVersion int
+		var content, options sql.NullString
+		if err := rows.Scan(&a.QuestionID, &a.Option, &a.QuestionVersion, &currentVersion, &content, &options); err != nil {
+			return nil, err
+		}
+		a.Question = content.String
+		var labels []string
+		if options.Valid {
+			json.Unmarshal([]byte(options.String), &labels)
+		}
+		if a.Option >= 1 && a.Option <= len(labels) {
+			a.Answer = labels[a.Option-1]
+		}
+		a.QuestionChanged = a.QuestionVersion > 0 && a.QuestionVersion < currentVersion
+		answers = append(answers, a)
+	}
+	return answers, rows.Err()
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"slices"
+	"time"
+
+	"auth"
+)
+
+// A draft is discarded when it hasn't been saved for this long
+const (
+	draftLifetime        = 14 * 24 * time.Hour
+	draftCleanupInterval = time.Hour
+)
+
+// Answers saved part-way through the questionnaire. Each patient has at most one draft.
+type Draft struct {
+	Answers           map[int]int `json:"answers"`
+	Language          string      `json:"language,omitempty"`
+	CurrentQuestionID int         `json:"current_question_id,omitempty"` // Question the patient was on
+	SavedAt           string      `json:"saved_at"`
+	ExpiresAt         string      `json:"expires_at"`
+}
+
+// Load a patient's draft, unless it has expired
+func loadDraft(db *sql.DB, userID int) (*Draft, error) {
+	query := `SELECT Answers, IFNULL(Language, ''), IFNULL(CurrentQuestionID, 0), UpdatedAt
+              FROM AssessmentDrafts
+              WHERE UserID = ? AND UpdatedAt >= NOW() - INTERVAL ? SECOND`
+	var draft Draft
+	var answers string
+	var savedAt time.Time
+	err := db.QueryRow(query, userID, int(draftLifetime.Seconds())).Scan(&answers, &draft.Language, &draft.CurrentQuestionID, &savedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(answers), &draft.Answers); err != nil {
+		return nil, err
+	}
+	draft.SavedAt = savedAt.Format("2006-01-02 15:04:05")
+	draft.ExpiresAt = savedAt.Add(draftLifetime).Format("2006-01-02 15:04:05")
+	return &draft, nil
+}
+
+func deleteDraft(db *sql.DB, userID int) {
+	if _, err := db.Exec("DELETE FROM AssessmentDrafts WHERE UserID = ?", userID); err != nil {
+		log.Println("Failed to delete draft:", err)
+	}
+}
+
+// Remove drafts that haven't been saved within draftLifetime, every draftCleanupInterval
+func expireDrafts(db *sql.DB) {
+	for ; ; time.Sleep(draftCleanupInterval) {
+		result, err := db.Exec("DELETE FROM AssessmentDrafts WHERE UpdatedAt < NOW() - INTERVAL ? SECOND", int(draftLifetime.Seconds()))
+		if err != nil {
+			log.Println("Failed to expire drafts:", err)
+			continue
+		}
+		if expired, _ := result.RowsAffected(); expired > 0 {
+			log.Printf("Expired %d stale drafts\n", expired)
+		}
+	}
+}
+
+// Save the patient's answers so far, replacing any earlier draft. Answers may be incomplete, but
+// each one must be a valid option for a question that is asked given the other answers.
+func saveDraftHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
+	var req struct {
+		Answers           map[int]int `json:"answers"`
+		Language          string      `json:"language"`
+		CurrentQuestionID int         `json:"current_question_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Println("Invalid JSON request")
+		http.Error(w, "Invalid JSON request", http.StatusBadRequest)
+		return
+	}
+	if req.Language != "" && !slices.Contains(questionLanguages, req.Language) {
+		http.Error(w, "Unsupported language", http.StatusBadRequest)
+		return
+	}
+	if req.Answers == nil {
+		req.Answers = map[int]int{}
+	}
+
+	flow, err := loadFlow(db)
+	if err != nil {
+		log.Println("Failed to load questionnaire flow:", err)
+		http.Error(w, "Failed to save draft", http.StatusInternalServerError)
+		return
+	}
+	problems := []AnswerError{}
+	for _, problem := range validateFlowAnswers(flow, req.Answers) {
+		if problem.Code != "missing" {
+			problems = append(problems, problem)
+		}
+	}
+	if len(problems) > 0 {
+		writeAnswerErrors(w, problems)
+		return
+	}
+
+	caller, _ := auth.CallerFromContext(r.Context())
+	answers, _ := json.Marshal(req.Answers)
+	_, err = db.Exec(`INSERT INTO AssessmentDrafts (UserID, Answers, Language, CurrentQuestionID, UpdatedAt)
+                      VALUES (?, ?, NULLIF(?, ''), NULLIF(?, 0), NOW())
+                      ON DUPLICATE KEY UPDATE Answers = VALUES(Answers), Language = VALUES(Language), CurrentQuestionID = VALUES(CurrentQuestionID), UpdatedAt = NOW()`,
+		caller.ID, string(answers), req.Language, req.CurrentQuestionID)
+	if err != nil {
+		log.Println("Database insert error:", err)
+		http.Error(w, "Failed to save draft", http.StatusInternalServerError)
+		return
+	}
+
+	draft, err := loadDraft(db, caller.ID)
+	if err != nil || draft == nil {
+		log.Println("Failed to load saved draft:", err)
+		http.Error(w, "Failed to save draft", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(draft)
+}
+
+// Return the patient's saved draft, to resume the questionnaire where they left off
+func getDraftHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
+	caller, _ := auth.CallerFromContext(r.Context())
+	draft, err := loadDraft(db, caller.ID)
+	if err != nil {
+		log.Println("Database query error:", err)
+		http.Error(w, "Failed to fetch draft", http.StatusInternalServerError)
+		return
+	}
+	if draft == nil {
+		http.Error(w, "No saved draft found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(draft)
+}
+
+// Discard the patient's draft, e.g. to start the questionnaire again
+func deleteDraftHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
+	caller, _ := auth.CallerFromContext(r.Context())
+	if _, err := db.Exec("DELETE FROM AssessmentDrafts WHERE UserID = ?", caller.ID); err != nil {
+		log.Println("Database delete error:", err)
+		http.Error(w, "Failed to delete draft", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Draft deleted"})
+}
+
+// Score and store the patient's draft as a completed assessment, then remove the draft. If the
+// answers are incomplete or invalid the draft is kept so the patient can finish it.
+func submitDraftHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
+	caller, _ := auth.CallerFromContext(r.Context())
+	draft, err := loadDraft(db, caller.ID)
+	if err != nil {
+		log.Println("Database query error:", err)
+		http.Error(w, "Failed to fetch draft", http.StatusInternalServerError)
+		return
+	}
+	if draft == nil {
+		http.Error(w, "No saved draft found", http.StatusNotFound)
+		return
+	}
+
+	submission := AssessmentSubmission{UserID: caller.ID, Answers: draft.Answers, Language: draft.Language}
+	if submitAssessment(w, db, submission) {
+		deleteDraft(db, caller.ID)
+	}
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// Branch rule for a question: it's only asked when question QuestionID was asked and answered
+// with one of Answers (1-based option indexes). A question without one is always asked.
+type ShowIf struct {
+	QuestionID int   `json:"question_id"`
+	Answers    []int `json:"answers"`
+}
+
+// One step of the questionnaire flow, as used to check submitted answers
+type flowStep struct {
+	QuestionID int
+	Version    int
+	Options    int // Number of options
+	ShowIf     *ShowIf
+}
+
+// A problem with one submitted answer, in the same form the Risk Assessment service reports them
+type AnswerError struct {
+	QuestionID int    `json:"question_id"`
+	Code       string `json:"code"` // missing, invalid_option, unknown_question or not_applicable
+	Message    string `json:"message"`
+}
+
+// Load the active questions with their option counts and branch rules, in display order
+func loadFlow(db *sql.DB) ([]flowStep, error) {
+	query := `SELECT q.QuestionID, q.Version, e.QuestionOptions, q.ShowIf
+              FROM Questions q
+              JOIN QuestionTranslations e ON e.QuestionID = q.QuestionID AND e.Language = 'English'
+              WHERE q.Status = 'Active'
+              ORDER BY q.DisplayOrder, q.QuestionID`
+	rows, err := db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	flow := []flowStep{}
+	for rows.Next() {
+		var step flowStep
+		var options string
+		var showIf sql.NullString
+		if err := rows.Scan(&step.QuestionID, &step.Version, &options, &showIf); err != nil {
+			return nil, err
+		}
+		var labels []string
+		if err := json.Unmarshal([]byte(options), &labels); err != nil {
+			return nil, fmt.Errorf("question %d has invalid options: %w", step.QuestionID, err)
+		}
+		step.Options = len(labels)
+		if step.ShowIf, err = parseShowIf(showIf); err != nil {
+			return nil, fmt.Errorf("question %d has an invalid branch rule: %w", step.QuestionID, err)
+		}
+		flow = append(flow, step)
+	}
+	return flow, rows.Err()
+}
+
+func parseShowIf(stored sql.NullString) (*ShowIf, error) {
+	if !stored.Valid || stored.String == "" {
+		return nil, nil
+	}
+	var showIf ShowIf
+	if err := json.Unmarshal([]byte(stored.String), &showIf); err != nil {
+		return nil, err
+	}
+	return &showIf, nil
+}
+
+// Work out which questions are asked for a set of answers. A question is asked when it has no
+// branch rule, or when the question its rule depends on was asked and given a listed answer.
+func askedQuestions(flow []flowStep, answers map[int]int) map[int]bool {
+	steps := map[int]flowStep{}
+	for _, step := range flow {
+		steps[step.QuestionID] = step
+	}
+
+	asked := map[int]bool{}
+	var isAsked func(questionID int, depth int) bool
+	isAsked = func(questionID int, depth int) bool {
+		if result, ok := asked[questionID]; ok {
+			return result
+		}
+		step := steps[questionID]
+		result := true
+		if step.ShowIf != nil && depth <= len(flow) { // Rules are checked for cycles when saved
+			_, parentActive := steps[step.ShowIf.QuestionID]
+			result = !parentActive ||
+				(isAsked(step.ShowIf.QuestionID, depth+1) && slices.Contains(step.ShowIf.Answers, answers[step.ShowIf.QuestionID]))
+		}
+		asked[questionID] = result
+		return result
+	}
+	for _, step := range flow {
+		isAsked(step.QuestionID, 0)
+	}
+	return asked
+}
+
+// Check submitted answers against the flow: every question that is asked must be answered with
+// one of its options, and questions the branch rules skip must be left out
+func validateFlowAnswers(flow []flowStep, answers map[int]int) []AnswerError {
+	asked := askedQuestions(flow, answers)
+	problems := []AnswerError{}
+	known := map[int]bool{}
+	for _, step := range flow {
+		known[step.QuestionID] = true
+		answer, answered := answers[step.QuestionID]
+		switch {
+		case asked[step.QuestionID] && !answered:
+			problems = append(problems, AnswerError{step.QuestionID, "missing", fmt.Sprintf("Question %d has not been answered", step.QuestionID)})
+		case !asked[step.QuestionID] && answered:
+			problems = append(problems, AnswerError{step.QuestionID, "not_applicable",
+				fmt.Sprintf("Question %d is skipped for the answer given to question %d and should not be answered", step.QuestionID, step.ShowIf.QuestionID)})
+		case answered && (answer < 1 || answer > step.Options):
+			problems = append(problems, AnswerError{step.QuestionID, "invalid_option", fmt.Sprintf("Answer %d is not an option for question %d", answer, step.QuestionID)})
+		}
+	}
+
+	unknown := []int{}
+	for questionID := range answers {
+		if !known[questionID] {
+			unknown = append(unknown, questionID)
+		}
+	}
+	slices.Sort(unknown)
+	for _, questionID := range unknown {
+		problems = append(problems, AnswerError{questionID, "unknown_question", fmt.Sprintf("Question %d is not part of the questionnaire", questionID)})
+	}
+	return problems
+}
+
+// Check a branch rule before it's saved for a question: it must depend on another question in the
+// bank, list valid options of that question, and not make the questions depend on each other
+func validateShowIf(questions []Question, questionID int, showIf *ShowIf) error {
+	if showIf == nil {
+		return nil
+	}
+	byID := map[int]Question{}
+	for _, q := range questions {
+		byID[q.QuestionID] = q
+	}
+
+	parent, ok := byID[showIf.QuestionID]
+	if !ok {
+		return fmt.Errorf("show_if: question %d does not exist", showIf.QuestionID)
+	}
+	if showIf.QuestionID == questionID {
+		return fmt.Errorf("show_if: a question can't depend on itself")
+	}
+	if parent.Status != questionActive {
+		return fmt.Errorf("show_if: question %d is retired", showIf.QuestionID)
+	}
+	if len(showIf.Answers) == 0 {
+		return fmt.Errorf("show_if: at least one answer is required")
+	}
+	options := len(parent.Translations[sourceLanguage].Options)
+	for _, answer := range showIf.Answers {
+		if answer < 1 || answer > options {
+			return fmt.Errorf("show_if: question %d has no option %d", showIf.QuestionID, answer)
+		}
+	}
+
+	// Follow the chain of rules up from the parent; reaching this question again is a cycle
+	seen := map[int]bool{questionID: true}
+	for current := parent; current.ShowIf != nil; current = byID[current.ShowIf.QuestionID] {
+		if seen[current.ShowIf.QuestionID] {
+			return fmt.Errorf("show_if: question %d already depends on question %d", showIf.QuestionID, questionID)
+		}
+		seen[current.ShowIf.QuestionID] = true
+	}
+	return nil
+}
+
+// Active questions whose branch rules depend on a question
+func dependentQuestions(questions []Question, questionID int) []string {
+	dependents := []string{}
+	for _, q := range questions {
+		if q.Status == questionActive && q.ShowIf != nil && q.ShowIf.QuestionID == questionID {
+			dependents = append(dependents, fmt.Sprint(q.QuestionID))
+		}
+	}
+	return dependents
+}
+
+// Reject answers that don't follow the questionnaire's branch rules, in the same 400 response
+// the Risk Assessment service gives for invalid answers
+func writeAnswerErrors(w http.ResponseWriter, problems []AnswerError) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message": "Some answers are missing or invalid",
+		"errors":  problems,
+	})
+}
+
+func retireBlockedMessage(questionID int, dependents []string) string {
+	return fmt.Sprintf("Question %d decides whether questions %s are asked; change their show_if first", questionID, strings.Join(dependents, ", "))
+}
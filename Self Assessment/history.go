@@ -0,0 +1,188 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Page sizes for the assessment history
+const (
+	defaultHistoryLimit = 20
+	maxHistoryLimit     = 100
+)
+
+// Sort orders for the assessment history. Ties are broken by AssessmentID in the same direction,
+// so every row has a fixed place to resume from.
+var historySorts = map[string]struct {
+	column     string
+	descending bool
+}{
+	"newest":        {"DateCreated", true},
+	"oldest":        {"DateCreated", false},
+	"highest_score": {"TotalScore", true},
+	"lowest_score":  {"TotalScore", false},
+}
+
+// Filters and position of one page of a patient's assessment history
+type historyQuery struct {
+	UserID     int
+	From, To   time.Time // To is exclusive; zero means no bound
+	RiskLevels []string
+	Sort       string
+	Limit      int
+	After      *historyCursor
+}
+
+// Where the previous page ended: the sort key and ID of its last assessment. Sent to clients as
+// an opaque base64 string.
+type historyCursor struct {
+	Sort         string    `json:"sort"`
+	AssessmentID int       `json:"id"`
+	DateCreated  time.Time `json:"date,omitempty"`
+	TotalScore   int       `json:"score,omitempty"`
+}
+
+func (c historyCursor) encode() string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeHistoryCursor(value, sort string) (*historyCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, fmt.Errorf("cursor is invalid")
+	}
+	var cursor historyCursor
+	if err := json.Unmarshal(data, &cursor); err != nil || cursor.AssessmentID == 0 {
+		return nil, fmt.Errorf("cursor is invalid")
+	}
+	if cursor.Sort != sort {
+		return nil, fmt.Errorf("cursor was issued for sort %q; send the same sort and filters for every page", cursor.Sort)
+	}
+	return &cursor, nil
+}
+
+// Build a historyQuery from the request fields, checking each one
+func newHistoryQuery(userID int, from, to, sort string, riskLevels []string, limit int, cursor string) (historyQuery, error) {
+	q := historyQuery{UserID: userID, RiskLevels: riskLevels, Sort: sort, Limit: limit}
+	var err error
+	if from != "" {
+		if q.From, err = time.Parse("2006-01-02", from); err != nil {
+			return q, fmt.Errorf("from must be a date in YYYY-MM-DD format")
+		}
+	}
+	if to != "" {
+		if q.To, err = time.Parse("2006-01-02", to); err != nil {
+			return q, fmt.Errorf("to must be a date in YYYY-MM-DD format")
+		}
+		q.To = q.To.AddDate(0, 0, 1)
+	}
+	if !q.From.IsZero() && !q.To.IsZero() && !q.From.Before(q.To) {
+		return q, fmt.Errorf("from must not be after to")
+	}
+	for _, level := range riskLevels {
+		if _, ok := riskLevelRank[level]; !ok {
+			return q, fmt.Errorf("unknown risk level %q; use Low, Moderate or High", level)
+		}
+	}
+	if q.Sort == "" {
+		q.Sort = "newest"
+	}
+	if _, ok := historySorts[q.Sort]; !ok {
+		return q, fmt.Errorf("sort must be newest, oldest, highest_score or lowest_score")
+	}
+	if q.Limit == 0 {
+		q.Limit = defaultHistoryLimit
+	}
+	if q.Limit < 1 || q.Limit > maxHistoryLimit {
+		return q, fmt.Errorf("limit must be between 1 and %d", maxHistoryLimit)
+	}
+	if cursor != "" {
+		if q.After, err = decodeHistoryCursor(cursor, q.Sort); err != nil {
+			return q, err
+		}
+	}
+	return q, nil
+}
+
+// WHERE clause for the filters, without the cursor, so the same one gives the total
+func (q historyQuery) filters() (string, []interface{}) {
+	conditions := []string{"UserID = ?"}
+	args := []interface{}{q.UserID}
+	if !q.From.IsZero() {
+		conditions = append(conditions, "DateCreated >= ?")
+		args = append(args, q.From)
+	}
+	if !q.To.IsZero() {
+		conditions = append(conditions, "DateCreated < ?")
+		args = append(args, q.To)
+	}
+	if len(q.RiskLevels) > 0 {
+		conditions = append(conditions, "RiskLevel IN ("+strings.TrimSuffix(strings.Repeat("?, ", len(q.RiskLevels)), ", ")+")")
+		for _, level := range q.RiskLevels {
+			args = append(args, level)
+		}
+	}
+	return strings.Join(conditions, " AND "), args
+}
+
+// Load one page of the history, plus the cursor for the next page ("" on the last page)
+func loadHistoryPage(db *sql.DB, q historyQuery) ([]Assessment, string, error) {
+	sort := historySorts[q.Sort]
+	where, args := q.filters()
+	direction, compare := "ASC", ">"
+	if sort.descending {
+		direction, compare = "DESC", "<"
+	}
+	if q.After != nil {
+		var after interface{} = q.After.TotalScore
+		if sort.column == "DateCreated" {
+			after = q.After.DateCreated
+		}
+		where += fmt.Sprintf(" AND (%[1]s %[2]s ? OR (%[1]s = ? AND AssessmentID %[2]s ?))", sort.column, compare)
+		args = append(args, after, after, q.After.AssessmentID)
+	}
+
+	// One row more than the page shows whether there is a next page
+	query := fmt.Sprintf(`SELECT AssessmentID, TotalScore, RiskLevel, Recommendation, DateCreated
+              FROM Assessments
+              WHERE %s
+              ORDER BY %s %s, AssessmentID %s
+              LIMIT ?`, where, sort.column, direction, direction)
+	args = append(args, q.Limit+1)
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, "", err
+	}
+	defer rows.Close()
+
+	assessments := []Assessment{}
+	var last historyCursor
+	for rows.Next() {
+		var assessment Assessment
+		var dateCreated time.Time
+		if err := rows.Scan(&assessment.AssessmentID, &assessment.TotalScore, &assessment.RiskLevel, &assessment.Recommendation, &dateCreated); err != nil {
+			return nil, "", err
+		}
+		if len(assessments) == q.Limit {
+			return assessments, last.encode(), rows.Err()
+		}
+		assessment.DateCreated = dateCreated.Format("2006-01-02 15:04:05")
+		assessments = append(assessments, assessment)
+		last = historyCursor{Sort: q.Sort, AssessmentID: assessment.AssessmentID, DateCreated: dateCreated, TotalScore: assessment.TotalScore}
+	}
+	return assessments, "", rows.Err()
+}
+
+// Number of assessments matching the filters, across all pages
+func countHistory(db *sql.DB, q historyQuery) (int, error) {
+	where, args := q.filters()
+	var total int
+	err := db.QueryRow("SELECT COUNT(*) FROM Assessments WHERE "+where, args...).Scan(&total)
+	return total, err
+}
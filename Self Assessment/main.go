@@ -5,10 +5,13 @@ import (
 	"database/sql"
 	"encoding/json"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
 	"os"
-	"time"
+	"slices"
+
+	"auth"
 
 	_ "github.com/go-sql-driver/mysql"
 	"github.com/gorilla/mux"
@@ -25,6 +28,9 @@ func connectDB(dbUser, dbPassword, dbHost, dbName string) (*sql.DB, error) {
 	return db, db.Ping()
 }
 
+// Secret used to verify access tokens and to sign service tokens, loaded from JWT_SECRET in main
+var jwtSecret []byte
+
 func main() {
 	// Get database connection details from environment variables
 	dbUser, dbPassword, dbHost, dbName := os.Getenv("DB_USER"), os.Getenv("DB_PASSWORD"), os.Getenv("DB_HOST"), os.Getenv("DB_NAME")
@@ -46,35 +52,104 @@ func main() {
 	}
 	defer db.Close()
 
+	// Copy answers from the legacy QuestionResponses blobs into AssessmentAnswers
+	if err := migrateLegacyAnswers(db); err != nil {
+		log.Fatalf("Failed to migrate legacy answers: %v", err)
+	}
+
+	// Get token signing secret from environment variables
+	jwtSecret = auth.SecretFromEnv()
+
+	// Run a maintenance command such as `rescore` instead of the server when one is given
+	if len(os.Args) > 1 {
+		runCommand(db, os.Args[1:])
+	}
+
 	// Initialize the router
 	router := mux.NewRouter()
+	verifier := &auth.Verifier{Secret: jwtSecret, CareTeamURL: "http://localhost:5004"}
 
 	// API Routes
 	router.HandleFunc("/api/questionnaire", func(w http.ResponseWriter, r *http.Request) {
 		questionnaireHandler(w, r, db)
 	}).Methods("GET")
-	router.HandleFunc("/api/addAssessmentResults", func(w http.ResponseWriter, r *http.Request) {
+
+	// Question bank administration
+	router.HandleFunc("/api/questions", verifier.RequirePermission(auth.PermManageQuestions, func(w http.ResponseWriter, r *http.Request) {
+		listQuestionsHandler(w, r, db)
+	})).Methods("GET")
+	router.HandleFunc("/api/questions", verifier.RequirePermission(auth.PermManageQuestions, func(w http.ResponseWriter, r *http.Request) {
+		createQuestionHandler(w, r, db)
+	})).Methods("POST")
+	router.HandleFunc("/api/questions/translationCheck", verifier.RequirePermission(auth.PermManageQuestions, func(w http.ResponseWriter, r *http.Request) {
+		translationCheckHandler(w, r, db)
+	})).Methods("GET")
+	router.HandleFunc("/api/questions/{id:[0-9]+}", verifier.RequirePermission(auth.PermManageQuestions, func(w http.ResponseWriter, r *http.Request) {
+		getQuestionHandler(w, r, db)
+	})).Methods("GET")
+	router.HandleFunc("/api/questions/{id:[0-9]+}", verifier.RequirePermission(auth.PermManageQuestions, func(w http.ResponseWriter, r *http.Request) {
+		updateQuestionHandler(w, r, db)
+	})).Methods("PUT")
+	router.HandleFunc("/api/questions/{id:[0-9]+}", verifier.RequirePermission(auth.PermManageQuestions, func(w http.ResponseWriter, r *http.Request) {
+		retireQuestionHandler(w, r, db)
+	})).Methods("DELETE")
+
+	router.HandleFunc("/api/addAssessmentResults", verifier.RequirePermission(auth.PermSubmitAssessment, func(w http.ResponseWriter, r *http.Request) {
 		addAssessmentHandler(w, r, db)
-	}).Methods("POST")
-	router.HandleFunc("/api/getLastAssessment", func(w http.ResponseWriter, r *http.Request) {
+	})).Methods("POST")
+
+	// Drafts of a questionnaire in progress, for the patient to resume later
+	router.HandleFunc("/api/saveDraft", verifier.RequirePermission(auth.PermSubmitAssessment, func(w http.ResponseWriter, r *http.Request) {
+		saveDraftHandler(w, r, db)
+	})).Methods("POST")
+	router.HandleFunc("/api/getDraft", verifier.RequirePermission(auth.PermSubmitAssessment, func(w http.ResponseWriter, r *http.Request) {
+		getDraftHandler(w, r, db)
+	})).Methods("GET")
+	router.HandleFunc("/api/deleteDraft", verifier.RequirePermission(auth.PermSubmitAssessment, func(w http.ResponseWriter, r *http.Request) {
+		deleteDraftHandler(w, r, db)
+	})).Methods("DELETE")
+	router.HandleFunc("/api/submitDraft", verifier.RequirePermission(auth.PermSubmitAssessment, func(w http.ResponseWriter, r *http.Request) {
+		submitDraftHandler(w, r, db)
+	})).Methods("POST")
+
+	router.HandleFunc("/api/getLastAssessment", verifier.Require(func(w http.ResponseWriter, r *http.Request) {
 		getLastAssessmentHandler(w, r, db)
-	}).Methods("POST")
-	router.HandleFunc("/api/getAssessment", func(w http.ResponseWriter, r *http.Request) {
+	})).Methods("POST")
+	router.HandleFunc("/api/getAssessment", verifier.Require(func(w http.ResponseWriter, r *http.Request) {
 		getAssessmentHandler(w, r, db)
-	}).Methods("POST")
-	router.HandleFunc("/api/assessmentHistory", func(w http.ResponseWriter, r *http.Request) {
+	})).Methods("POST")
+	router.HandleFunc("/api/assessmentHistory", verifier.Require(func(w http.ResponseWriter, r *http.Request) {
 		assessmentHistoryHandler(w, r, db)
-	}).Methods("POST")
+	})).Methods("POST")
+	router.HandleFunc("/api/assessmentTrend", verifier.Require(func(w http.ResponseWriter, r *http.Request) {
+		assessmentTrendHandler(w, r, db)
+	})).Methods("POST")
+
+	// Population statistics over the caller's patients, for care teams
+	router.HandleFunc("/api/cohortAnalytics", verifier.RequirePermission(auth.PermReadPatientRecords, func(w http.ResponseWriter, r *http.Request) {
+		cohortAnalyticsHandler(w, r, db)
+	})).Methods("GET")
+
+	// Personal data requests, called by the User service with the patient's token
+	router.HandleFunc("/api/exportUserData", verifier.RequirePermission(auth.PermManageOwnData, func(w http.ResponseWriter, r *http.Request) {
+		exportUserDataHandler(w, r, db)
+	})).Methods("GET")
+	router.HandleFunc("/api/deleteUserData", verifier.RequirePermission(auth.PermManageOwnData, func(w http.ResponseWriter, r *http.Request) {
+		deleteUserDataHandler(w, r, db)
+	})).Methods("DELETE")
 
 	// CORS Configuration
 	c := cors.New(cors.Options{
 		AllowedOrigins:   []string{"*"},
-		AllowedMethods:   []string{"GET", "POST"},
-		AllowedHeaders:   []string{"Content-Type"},
+		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE"},
+		AllowedHeaders:   []string{"Content-Type", "Authorization"},
 		AllowCredentials: true,
 	})
 	handler := c.Handler(router)
 
+	// Remove drafts nobody has come back to
+	go expireDrafts(db)
+
 	log.Printf("Starting server on :%s", localPort)
 	if err := http.ListenAndServe(":"+localPort, handler); err != nil {
 		log.Fatalf("Server failed to start: %v", err)
@@ -84,12 +159,17 @@ func main() {
 // Structure to handle assessment submissions
 
 type Assessment struct {
-	AssessmentID   int    `json:"id,omitempty"`
-	TotalScore     int    `json:"totalScore"`
-	RiskLevel      string `json:"riskLevel"`
-	Recommendation string `json:"recommendation"`
-	DateCreated    string `json:"dateCreated,omitempty"`
-	UserID	   	   int 	  `json:"user_id,omitempty"`
+	AssessmentID   int                `json:"id,omitempty"`
+	TotalScore     int                `json:"totalScore"`
+	RiskLevel      string             `json:"riskLevel"`
+	Recommendation string             `json:"recommendation"`
+	DateCreated    string             `json:"dateCreated,omitempty"`
+	UserID         int                `json:"user_id,omitempty"`
+	ModelVersion   int                `json:"modelVersion,omitempty"` // Risk model version that produced the score
+	Breakdown      json.RawMessage    `json:"breakdown,omitempty"`    // Points each answer contributed
+	TopFactors     json.RawMessage    `json:"topFactors,omitempty"`   // Factors that added the most risk
+	Advice         json.RawMessage    `json:"advice,omitempty"`       // Targeted advice for the patient's answers
+	Answers        []AnsweredQuestion `json:"answers,omitempty"`      // The answers given, as text
 }
 
 // Handler to retrieve questionnaire questions based on language (GET request with query string)
@@ -100,24 +180,21 @@ func questionnaireHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
 		language = "English" // Default to English if not specified
 	}
 
-	// Map supported languages to their respective tables
-	languageMap := map[string]string{
-		"English": "QuestionsEn",
-		"Chinese": "QuestionsCn",
-		"Malay":   "QuestionsMy",
-		"Tamil":   "QuestionsTa",
-	}
-
 	// Check if language is supported
-	tableName, exists := languageMap[language]
-	if !exists {
+	if !slices.Contains(questionLanguages, language) {
 		http.Error(w, "Unsupported language", http.StatusBadRequest)
 		return
 	}
 
-	// Query the database for questions in the selected language
-	query := fmt.Sprintf("SELECT QuestionID, QuestionContent, QuestionOptions FROM %s", tableName)
-	rows, err := db.Query(query)
+	// Active questions from the question bank, in display order, with the branch rules that decide
+	// whether each one is asked. A question not yet translated into the selected language is shown in English.
+	query := `SELECT q.QuestionID, IFNULL(t.QuestionContent, e.QuestionContent), IFNULL(t.QuestionOptions, e.QuestionOptions), q.ShowIf
+              FROM Questions q
+              JOIN QuestionTranslations e ON e.QuestionID = q.QuestionID AND e.Language = 'English'
+              LEFT JOIN QuestionTranslations t ON t.QuestionID = q.QuestionID AND t.Language = ?
+              WHERE q.Status = 'Active'
+              ORDER BY q.DisplayOrder, q.QuestionID`
+	rows, err := db.Query(query, language)
 	if err != nil {
 		log.Println(err)
 		http.Error(w, "Database error", http.StatusInternalServerError)
@@ -132,18 +209,24 @@ func questionnaireHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
 		var questionID int
 		var questionContent string
 		var questionOptions string // Stored in JSON format
+		var showIf sql.NullString
 
-		if err := rows.Scan(&questionID, &questionContent, &questionOptions); err != nil {
+		if err := rows.Scan(&questionID, &questionContent, &questionOptions, &showIf); err != nil {
 			log.Println("Data retrieval error: ", err)
 			http.Error(w, "Data retrieval error", http.StatusInternalServerError)
 			return
 		}
 
-		questions = append(questions, map[string]interface{}{
+		question := map[string]interface{}{
 			"question_id":      questionID,
 			"question_content": questionContent,
 			"question_options": json.RawMessage(questionOptions), // Ensure JSON format
-		})
+		}
+		// Only ask this question when an earlier one got one of the listed answers
+		if showIf.Valid {
+			question["show_if"] = json.RawMessage(showIf.String)
+		}
+		questions = append(questions, question)
 	}
 
 	// Send the response
@@ -170,45 +253,72 @@ func sendNotification(userID int, riskLevel string) {
 	})
 
 	// Send POST request to Alerts Service
-	resp, err := http.Post("http://localhost:5002/api/postNotifications", "application/json", bytes.NewBuffer(notificationBody))
+	resp, err := postAsService("http://localhost:5002/api/postNotifications", notificationBody)
 	if err != nil {
 		log.Println("Failed to send notification:", err)
 		return
 	}
 	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		log.Printf("Alert service returned status %d for notification\n", resp.StatusCode)
+		return
+	}
 
 	// Log notification response
 	log.Printf("Notification sent for user %d with risk level %s\n", userID, riskLevel)
 }
 
+// POST JSON to another service on this service's own behalf, with a short-lived service token
+func postAsService(url string, body []byte) (*http.Response, error) {
+	token, err := auth.IssueServiceToken(jwtSecret, "self_assessment")
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+	return http.DefaultClient.Do(req)
+}
+
 // Call Alert Service to send doctor alert
-func sendAlertToDoctors(userID int, assessmentID int64) {
-	log.Printf("Sending alert for high-risk user %d (Assessment ID: %d)\n", userID, assessmentID)
+func sendAlertToDoctors(userID int, assessmentID int64, reason string) {
+	log.Printf("Sending alert for user %d (Assessment ID: %d): %s\n", userID, assessmentID, reason)
 
 	// Create JSON payload
 	alertBody, _ := json.Marshal(map[string]interface{}{
 		"assessment_id": assessmentID,
+		"user_id":       userID,
+		"reason":        reason,
 	})
 
 	// Send POST request to Notification Service
-	resp, err := http.Post("http://localhost:5002/api/postAlerts", "application/json", bytes.NewBuffer(alertBody))
+	resp, err := postAsService("http://localhost:5002/api/postAlerts", alertBody)
 	if err != nil {
 		log.Println("Failed to send alert to doctors:", err)
 		return
 	}
 	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		log.Printf("Alert service returned status %d for assessment %d\n", resp.StatusCode, assessmentID)
+		return
+	}
 
 	log.Printf("Alert successfully sent for assessment %d\n", assessmentID)
 }
 
+// Answers submitted for scoring
+type AssessmentSubmission struct {
+	UserID   int         `json:"user_id"`
+	Answers  map[int]int `json:"answers"`
+	Language string      `json:"language,omitempty"` // Language for advice, as used for the questionnaire
+}
+
 // Add Results from Risk Assessment into DB
 func addAssessmentHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
-	type Request struct {
-		UserID  int         `json:"user_id"`
-		Answers map[int]int `json:"answers"`
-	}
-
-	var req Request
+	var req AssessmentSubmission
 
 	// Decode JSON request
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -217,19 +327,34 @@ func addAssessmentHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
 		return
 	}
 
-	// Validate User ID
-	if req.UserID <= 0 {
-		log.Println("Invalid UserID:", req.UserID)
-		http.Error(w, "Invalid or missing user_id", http.StatusBadRequest)
+	// Assessments are always submitted by the patient themselves
+	caller, _ := auth.CallerFromContext(r.Context())
+	if req.UserID != 0 && req.UserID != caller.ID {
+		http.Error(w, "Forbidden", http.StatusForbidden)
 		return
 	}
+	req.UserID = caller.ID
 
-	// Convert answers to JSON format
-	answersJSON, err := json.Marshal(req.Answers)
+	// A complete submission replaces any saved draft
+	if submitAssessment(w, db, req) {
+		deleteDraft(db, req.UserID)
+	}
+}
+
+// Score a submission with the Risk Assessment service, store it and notify the patient's care
+// team if needed. Writes the response, and reports whether the assessment was stored.
+func submitAssessment(w http.ResponseWriter, db *sql.DB, req AssessmentSubmission) bool {
+	// Answers must follow the questionnaire's branch rules: skipped questions left out, the rest answered
+	flow, err := loadFlow(db)
 	if err != nil {
-		log.Println("Error marshalling answers JSON:", err)
+		log.Println("Failed to load questionnaire flow:", err)
 		http.Error(w, "Failed to process answers", http.StatusInternalServerError)
-		return
+		return false
+	}
+	if problems := validateFlowAnswers(flow, req.Answers); len(problems) > 0 {
+		log.Println("Answers don't follow the questionnaire flow for user", req.UserID)
+		writeAnswerErrors(w, problems)
+		return false
 	}
 
 	// Prepare JSON body for Risk Assessment Service
@@ -237,7 +362,7 @@ func addAssessmentHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
 	if err != nil {
 		log.Println("Error encoding risk assessment request:", err)
 		http.Error(w, "Failed to encode risk assessment request", http.StatusInternalServerError)
-		return
+		return false
 	}
 
 	// Call Risk Assessment Service
@@ -245,35 +370,80 @@ func addAssessmentHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
 	if err != nil {
 		log.Println("Error calling Risk Assessment Service:", err)
 		http.Error(w, "Failed to process risk assessment", http.StatusInternalServerError)
-		return
+		return false
 	}
 	defer riskResponse.Body.Close()
 
+	// Rejected answers are passed back to the client as-is, with the problem for each question
+	if riskResponse.StatusCode == http.StatusBadRequest {
+		log.Println("Risk Assessment Service rejected the answers for user", req.UserID)
+		w.Header().Set("Content-Type", riskResponse.Header.Get("Content-Type"))
+		w.WriteHeader(http.StatusBadRequest)
+		io.Copy(w, riskResponse.Body)
+		return false
+	} else if riskResponse.StatusCode != http.StatusOK {
+		log.Println("Risk Assessment Service returned status", riskResponse.StatusCode)
+		http.Error(w, "Failed to process risk assessment", http.StatusInternalServerError)
+		return false
+	}
+
 	// Parse Risk Assessment Response
 	var riskResult struct {
-		TotalScore     int    `json:"total_score"`
-		RiskLevel      string `json:"risk_level"`
-		Recommendation string `json:"recommendation"`
+		TotalScore     int             `json:"total_score"`
+		RiskLevel      string          `json:"risk_level"`
+		Recommendation string          `json:"recommendation"`
+		ModelVersion   int             `json:"model_version"`
+		Breakdown      json.RawMessage `json:"breakdown"`
+		TopFactors     json.RawMessage `json:"top_factors"`
+		Advice         json.RawMessage `json:"advice"`
 	}
 
 	if err := json.NewDecoder(riskResponse.Body).Decode(&riskResult); err != nil {
 		log.Println("Error decoding risk assessment response:", err)
 		http.Error(w, "Failed to parse risk assessment response", http.StatusInternalServerError)
-		return
+		return false
+	}
+
+	// Store results in the database, with each answer and the question version it was given for
+	tx, err := db.Begin()
+	if err != nil {
+		log.Println("Database transaction error:", err)
+		http.Error(w, "Failed to store assessment data", http.StatusInternalServerError)
+		return false
 	}
+	defer tx.Rollback()
 
-	// Store results in the database
-	insertQuery := `INSERT INTO Assessments (UserID, QuestionResponses, TotalScore, RiskLevel, Recommendation, DateCreated) 
-                    VALUES (?, ?, ?, ?, ?, NOW())`
-	result, err := db.Exec(insertQuery, req.UserID, answersJSON, riskResult.TotalScore, riskResult.RiskLevel, riskResult.Recommendation)
+	insertQuery := `INSERT INTO Assessments (UserID, TotalScore, RiskLevel, Recommendation, ModelVersion, ScoreBreakdown, TopFactors, Advice, DateCreated) 
+                    VALUES (?, ?, ?, ?, ?, ?, ?, ?, NOW())`
+	result, err := tx.Exec(insertQuery, req.UserID, riskResult.TotalScore, riskResult.RiskLevel, riskResult.Recommendation, riskResult.ModelVersion,
+		string(riskResult.Breakdown), string(riskResult.TopFactors), string(riskResult.Advice))
 	if err != nil {
 		log.Println("Database insert error:", err)
 		http.Error(w, "Failed to store assessment data", http.StatusInternalServerError)
-		return
+		return false
 	}
 
 	assessmentID, _ := result.LastInsertId()
 
+	versions := map[int]int{}
+	for _, step := range flow {
+		versions[step.QuestionID] = step.Version
+	}
+	language := req.Language
+	if language == "" {
+		language = "English"
+	}
+	if err := saveAnswers(tx, assessmentID, req.Answers, language, versions); err != nil {
+		log.Println("Database insert error:", err)
+		http.Error(w, "Failed to store assessment data", http.StatusInternalServerError)
+		return false
+	}
+	if err := tx.Commit(); err != nil {
+		log.Println("Database commit error:", err)
+		http.Error(w, "Failed to store assessment data", http.StatusInternalServerError)
+		return false
+	}
+
 	// if risk is moderate or high, Call Alert Service
 	if riskResult.RiskLevel == "Moderate" || riskResult.RiskLevel == "High" {
 		go sendNotification(req.UserID, riskResult.RiskLevel)
@@ -281,9 +451,12 @@ func addAssessmentHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
 
 	// **If risk is HIGH, send an alert to doctors**
 	if riskResult.RiskLevel == "High" {
-		go sendAlertToDoctors(req.UserID, assessmentID)
+		go sendAlertToDoctors(req.UserID, assessmentID, "High risk")
+	} else {
+		// Otherwise alert them if the patient is getting worse
+		go checkDeterioration(db, req.UserID, assessmentID)
 	}
-	
+
 	// Send response
 	response := map[string]interface{}{
 		"assessment_id":      assessmentID,
@@ -291,12 +464,17 @@ func addAssessmentHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
 		"total_score":        riskResult.TotalScore,
 		"risk_level":         riskResult.RiskLevel,
 		"recommendation":     riskResult.Recommendation,
+		"model_version":      riskResult.ModelVersion,
+		"breakdown":          riskResult.Breakdown,
+		"top_factors":        riskResult.TopFactors,
+		"advice":             riskResult.Advice,
 		"question_responses": req.Answers,
 	}
 
 	log.Println("Successfully stored assessment:", response)
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(response)
+	return true
 }
 
 // Retrieve results of last assessment
@@ -306,33 +484,37 @@ func getLastAssessmentHandler(w http.ResponseWriter, r *http.Request, db *sql.DB
 	}
 	var req Request
 
-	// Decode JSON request
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		log.Println("Invalid JSON request")
-		http.Error(w, "Invalid JSON request", http.StatusBadRequest)
-		return
+	// Decode JSON request (optional for patients, who always get their own records)
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			log.Println("Invalid JSON request")
+			http.Error(w, "Invalid JSON request", http.StatusBadRequest)
+			return
+		}
 	}
 
-	// Validate User ID
-	if req.UserID <= 0 {
-		log.Println("Invalid or missing User ID")
-		http.Error(w, "Invalid or missing user_id", http.StatusBadRequest)
+	// Resolve and authorise the patient from the access token
+	userID, ok := auth.PatientID(w, r, req.UserID)
+	if !ok {
 		return
 	}
 
 	// Query the database for the latest risk assessment for the user
-	query := `SELECT AssessmentID, TotalScore, RiskLevel, Recommendation 
+	query := `SELECT AssessmentID, TotalScore, RiskLevel, Recommendation, IFNULL(ModelVersion, 0), Advice 
               FROM Assessments 
               WHERE UserID = ? 
               ORDER BY DateCreated DESC LIMIT 1`
 
 	assessment := Assessment{}
+	var advice sql.NullString
 
-	err := db.QueryRow(query, req.UserID).Scan(
+	err := db.QueryRow(query, userID).Scan(
 		&assessment.AssessmentID,
 		&assessment.TotalScore,
 		&assessment.RiskLevel,
 		&assessment.Recommendation,
+		&assessment.ModelVersion,
+		&advice,
 	)
 
 	if err != nil {
@@ -345,6 +527,11 @@ func getLastAssessmentHandler(w http.ResponseWriter, r *http.Request, db *sql.DB
 		return
 	}
 
+	// Assessments taken before advice was recorded have none
+	if advice.Valid && advice.String != "" {
+		assessment.Advice = json.RawMessage(advice.String)
+	}
+
 	// Send JSON response
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(assessment)
@@ -353,7 +540,8 @@ func getLastAssessmentHandler(w http.ResponseWriter, r *http.Request, db *sql.DB
 // Retrieve results of a specific assessment
 func getAssessmentHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
 	type Request struct {
-		AssessmentID int `json:"assessment_id"`
+		AssessmentID int    `json:"assessment_id"`
+		Language     string `json:"language"` // Optional language for the answers; defaults to the one the assessment was taken in
 	}
 	var req Request
 
@@ -363,19 +551,28 @@ func getAssessmentHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
 		http.Error(w, "Invalid JSON request", http.StatusBadRequest)
 		return
 	}
+	if req.Language != "" && !slices.Contains(questionLanguages, req.Language) {
+		http.Error(w, "Unsupported language", http.StatusBadRequest)
+		return
+	}
 
 	// Query the database for the risk assessment for the user
-	query := `SELECT TotalScore, RiskLevel, Recommendation, UserID
+	query := `SELECT TotalScore, RiskLevel, Recommendation, UserID, IFNULL(ModelVersion, 0), ScoreBreakdown, TopFactors, Advice
               FROM Assessments 
               WHERE AssessmentID = ?`
 
 	assessment := Assessment{}
+	var breakdown, topFactors, advice sql.NullString
 
 	err := db.QueryRow(query, req.AssessmentID).Scan(
 		&assessment.TotalScore,
 		&assessment.RiskLevel,
 		&assessment.Recommendation,
 		&assessment.UserID,
+		&assessment.ModelVersion,
+		&breakdown,
+		&topFactors,
+		&advice,
 	)
 
 	if err != nil {
@@ -388,75 +585,101 @@ func getAssessmentHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
 		return
 	}
 
+	// Only the owning patient or their care team may view the assessment
+	caller, _ := auth.CallerFromContext(r.Context())
+	allowed, err := caller.CanAccessPatient(assessment.UserID)
+	if err != nil {
+		log.Println("Care team lookup error:", err)
+		http.Error(w, "Failed to fetch data", http.StatusInternalServerError)
+		return
+	}
+	if !allowed {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	// Assessments scored before breakdowns were recorded have none
+	if breakdown.Valid && breakdown.String != "" {
+		assessment.Breakdown = json.RawMessage(breakdown.String)
+	}
+	if topFactors.Valid && topFactors.String != "" {
+		assessment.TopFactors = json.RawMessage(topFactors.String)
+	}
+	if advice.Valid && advice.String != "" {
+		assessment.Advice = json.RawMessage(advice.String)
+	}
+
+	// The patient's answers, with the question and option text
+	if assessment.Answers, err = loadAnsweredQuestions(db, req.AssessmentID, req.Language); err != nil {
+		log.Println("Database query error:", err)
+		http.Error(w, "Failed to fetch data", http.StatusInternalServerError)
+		return
+	}
+
 	// Send JSON response
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(assessment)
 }
 
+// One page of a patient's assessments, newest first unless another sort is asked for. Send
+// next_cursor back as cursor, with the same filters and sort, for the following page.
 func assessmentHistoryHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
 	type Request struct {
-		UserID int `json:"user_id"`
+		UserID     int      `json:"user_id"`
+		From       string   `json:"from"`        // YYYY-MM-DD, inclusive
+		To         string   `json:"to"`          // YYYY-MM-DD, inclusive
+		RiskLevels []string `json:"risk_levels"` // Any of Low, Moderate and High
+		Sort       string   `json:"sort"`        // newest, oldest, highest_score or lowest_score
+		Limit      int      `json:"limit"`
+		Cursor     string   `json:"cursor"`
 	}
 	var req Request
 
-	// Decode JSON request
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		log.Println("Invalid JSON request")
-		http.Error(w, "Invalid JSON request", http.StatusBadRequest)
-		return
+	// Decode JSON request (optional for patients, who always get their own records)
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			log.Println("Invalid JSON request")
+			http.Error(w, "Invalid JSON request", http.StatusBadRequest)
+			return
+		}
 	}
 
-	// Validate User ID
-	if req.UserID <= 0 {
-		log.Println("Invalid or missing User ID")
-		http.Error(w, "Invalid or missing user_id", http.StatusBadRequest)
+	// Resolve and authorise the patient from the access token
+	userID, ok := auth.PatientID(w, r, req.UserID)
+	if !ok {
 		return
 	}
 
-	// Query the database for all risk assessments for the user
-	query := `SELECT AssessmentID, TotalScore, RiskLevel, Recommendation, DateCreated
-              FROM Assessments 
-              WHERE UserID = ? 
-              ORDER BY DateCreated DESC`
+	query, err := newHistoryQuery(userID, req.From, req.To, req.Sort, req.RiskLevels, req.Limit, req.Cursor)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
-	rows, err := db.Query(query, req.UserID)
+	assessments, nextCursor, err := loadHistoryPage(db, query)
 	if err != nil {
 		log.Println("Database query error:", err)
 		http.Error(w, "Failed to fetch data", http.StatusInternalServerError)
 		return
 	}
-	defer rows.Close()
-
-	var assessments []Assessment
-
-	// Iterate over rows
-	for rows.Next() {
-		var assessment Assessment
-		var dateCreated time.Time
-
-		if err := rows.Scan(
-			&assessment.AssessmentID,
-			&assessment.TotalScore,
-			&assessment.RiskLevel,
-			&assessment.Recommendation,
-			&dateCreated,
-		); err != nil {
-			log.Println("Error scanning row:", err)
-			http.Error(w, "Failed to process data", http.StatusInternalServerError)
-			return
-		}
-
-		assessment.DateCreated = dateCreated.Format("2006-01-02 15:04:05")
-		assessments = append(assessments, assessment)
+	total, err := countHistory(db, query)
+	if err != nil {
+		log.Println("Database query error:", err)
+		http.Error(w, "Failed to fetch data", http.StatusInternalServerError)
+		return
 	}
 
-	// Check if no records were found
-	if len(assessments) == 0 {
-		http.Error(w, "No risk assessments found for this user", http.StatusNotFound)
-		return
+	// No assessments is an empty page, not an error
+	response := map[string]interface{}{
+		"assessments": assessments,
+		"total":       total,
+		"next_cursor": nil,
+	}
+	if nextCursor != "" {
+		response["next_cursor"] = nextCursor
 	}
 
 	// Send JSON response
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(assessments)
+	json.NewEncoder(w).Encode(response)
 }
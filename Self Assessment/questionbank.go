@@ -0,0 +1,588 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"auth"
+
+	"github.com/gorilla/mux"
+)
+
+// Languages every active question must be translated into. English is the source language:
+// changing its wording or options makes a new question version, and translations written for an
+// older version are reported as outdated until they're updated.
+var questionLanguages = []string{"English", "Chinese", "Malay", "Tamil"}
+
+const sourceLanguage = "English"
+
+const (
+	questionActive  = "Active"
+	questionRetired = "Retired"
+
+	maxQuestionText = 500 // Column size of QuestionContent and QuestionOptions
+)
+
+// A question's wording and options in one language
+type Translation struct {
+	Content string   `json:"content"`
+	Options []string `json:"options"`
+	Version int      `json:"version"` // Question version it was written for; set by the server
+}
+
+// A question in the question bank. QuestionIDs never change or get reused, since stored answers
+// and risk models refer to them.
+type Question struct {
+	QuestionID   int                    `json:"question_id"`
+	DisplayOrder int                    `json:"display_order"`
+	Status       string                 `json:"status"`
+	Version      int                    `json:"version"`
+	UpdatedAt    string                 `json:"updated_at"`
+	ShowIf       *ShowIf                `json:"show_if,omitempty"` // Branch rule; nil when always asked
+	Translations map[string]Translation `json:"translations"`
+}
+
+// Load questions with all their translations, in display order. questionID 0 loads every question.
+func loadQuestions(db *sql.DB, questionID int, includeRetired bool) ([]Question, error) {
+	query := "SELECT QuestionID, DisplayOrder, Status, Version, UpdatedAt, ShowIf FROM Questions WHERE 1 = 1"
+	args := []interface{}{}
+	if questionID > 0 {
+		query += " AND QuestionID = ?"
+		args = append(args, questionID)
+	}
+	if !includeRetired {
+		query += " AND Status = 'Active'"
+	}
+	query += " ORDER BY DisplayOrder, QuestionID"
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	questions := []Question{}
+	index := map[int]int{}
+	for rows.Next() {
+		var q Question
+		var updatedAt time.Time
+		var showIf sql.NullString
+		if err := rows.Scan(&q.QuestionID, &q.DisplayOrder, &q.Status, &q.Version, &updatedAt, &showIf); err != nil {
+			return nil, err
+		}
+		if q.ShowIf, err = parseShowIf(showIf); err != nil {
+			return nil, fmt.Errorf("question %d has an invalid branch rule: %w", q.QuestionID, err)
+		}
+		q.UpdatedAt = updatedAt.Format("2006-01-02 15:04:05")
+		q.Translations = map[string]Translation{}
+		index[q.QuestionID] = len(questions)
+		questions = append(questions, q)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	translationQuery := "SELECT QuestionID, Language, QuestionContent, QuestionOptions, Version FROM QuestionTranslations"
+	translationArgs := []interface{}{}
+	if questionID > 0 {
+		translationQuery += " WHERE QuestionID = ?"
+		translationArgs = append(translationArgs, questionID)
+	}
+	translationRows, err := db.Query(translationQuery, translationArgs...)
+	if err != nil {
+		return nil, err
+	}
+	defer translationRows.Close()
+
+	for translationRows.Next() {
+		var id int
+		var language, options string
+		var t Translation
+		if err := translationRows.Scan(&id, &language, &t.Content, &options, &t.Version); err != nil {
+			return nil, err
+		}
+		i, ok := index[id]
+		if !ok {
+			continue // Retired question
+		}
+		if err := json.Unmarshal([]byte(options), &t.Options); err != nil {
+			return nil, fmt.Errorf("question %d (%s) has invalid options: %w", id, language, err)
+		}
+		questions[i].Translations[language] = t
+	}
+	return questions, translationRows.Err()
+}
+
+// Check a translation before it's stored, and return its options as stored
+func validateTranslation(language string, t Translation) (string, error) {
+	if !slices.Contains(questionLanguages, language) {
+		return "", fmt.Errorf("unsupported language %q (expected one of %s)", language, strings.Join(questionLanguages, ", "))
+	}
+	if strings.TrimSpace(t.Content) == "" {
+		return "", fmt.Errorf("%s: content is required", language)
+	}
+	if len(t.Content) > maxQuestionText {
+		return "", fmt.Errorf("%s: content is longer than %d characters", language, maxQuestionText)
+	}
+	if len(t.Options) == 0 {
+		return "", fmt.Errorf("%s: at least one option is required", language)
+	}
+	for i, option := range t.Options {
+		if strings.TrimSpace(option) == "" {
+			return "", fmt.Errorf("%s: option %d is empty", language, i+1)
+		}
+	}
+	options, _ := json.Marshal(t.Options)
+	if len(options) > maxQuestionText {
+		return "", fmt.Errorf("%s: options are longer than %d characters", language, maxQuestionText)
+	}
+	return string(options), nil
+}
+
+// Problem with a question's translation, reported by the translation check
+type TranslationIssue struct {
+	QuestionID int    `json:"question_id"`
+	Language   string `json:"language"`
+	Problem    string `json:"problem"` // missing, outdated or option_count
+	Message    string `json:"message"`
+}
+
+// Find active questions whose translations are missing, written for an older version, or don't
+// have the same number of options as the English question
+func translationIssues(questions []Question) []TranslationIssue {
+	issues := []TranslationIssue{}
+	for _, q := range questions {
+		if q.Status != questionActive {
+			continue
+		}
+		source, hasSource := q.Translations[sourceLanguage]
+		for _, language := range questionLanguages {
+			t, ok := q.Translations[language]
+			switch {
+			case !ok:
+				issues = append(issues, TranslationIssue{q.QuestionID, language, "missing",
+					fmt.Sprintf("Question %d has no %s translation", q.QuestionID, language)})
+			case t.Version < q.Version:
+				issues = append(issues, TranslationIssue{q.QuestionID, language, "outdated",
+					fmt.Sprintf("%s translation of question %d was written for version %d; the question is at version %d", language, q.QuestionID, t.Version, q.Version)})
+			case hasSource && len(t.Options) != len(source.Options):
+				issues = append(issues, TranslationIssue{q.QuestionID, language, "option_count",
+					fmt.Sprintf("%s translation of question %d has %d options; English has %d", language, q.QuestionID, len(t.Options), len(source.Options))})
+			}
+		}
+	}
+	return issues
+}
+
+// Store translations for a question. Changing the English text moves the question to a new
+// version; every translation written here is marked as written for the resulting version.
+func saveTranslations(tx *sql.Tx, questionID int, version int, existing map[string]Translation, translations map[string]Translation) error {
+	stored := map[string]string{}
+	for language, t := range translations {
+		options, err := validateTranslation(language, t)
+		if err != nil {
+			return questionRequestError{err}
+		}
+		stored[language] = options
+	}
+
+	source, ok := translations[sourceLanguage]
+	if !ok {
+		source = existing[sourceLanguage]
+	}
+	for language, t := range translations {
+		if language != sourceLanguage && len(source.Options) > 0 && len(t.Options) != len(source.Options) {
+			return questionRequestError{fmt.Errorf("%s: has %d options; English has %d", language, len(t.Options), len(source.Options))}
+		}
+	}
+
+	if t, ok := translations[sourceLanguage]; ok {
+		if previous, existed := existing[sourceLanguage]; existed && (previous.Content != t.Content || !slices.Equal(previous.Options, t.Options)) {
+			version++
+			if _, err := tx.Exec("UPDATE Questions SET Version = ? WHERE QuestionID = ?", version, questionID); err != nil {
+				return err
+			}
+		}
+	}
+
+	for language, t := range translations {
+		_, err := tx.Exec(`INSERT INTO QuestionTranslations (QuestionID, Language, QuestionContent, QuestionOptions, Version)
+                           VALUES (?, ?, ?, ?, ?)
+                           ON DUPLICATE KEY UPDATE QuestionContent = VALUES(QuestionContent), QuestionOptions = VALUES(QuestionOptions), Version = VALUES(Version)`,
+			questionID, language, t.Content, stored[language], version)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Error caused by the request rather than the database
+type questionRequestError struct{ error }
+
+func writeQuestion(w http.ResponseWriter, db *sql.DB, questionID int, status int) {
+	questions, err := loadQuestions(db, questionID, true)
+	if err != nil || len(questions) == 0 {
+		log.Println("Failed to load question:", err)
+		http.Error(w, "Failed to fetch question", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(questions[0])
+}
+
+func questionIDFromPath(w http.ResponseWriter, r *http.Request) (int, bool) {
+	questionID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil || questionID <= 0 {
+		http.Error(w, "Invalid question ID", http.StatusBadRequest)
+		return 0, false
+	}
+	return questionID, true
+}
+
+// List the question bank with every translation. Retired questions are included with ?include_retired=true.
+func listQuestionsHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
+	includeRetired := r.URL.Query().Get("include_retired") == "true"
+	questions, err := loadQuestions(db, 0, includeRetired)
+	if err != nil {
+		log.Println("Database query error:", err)
+		http.Error(w, "Failed to fetch questions", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"languages": questionLanguages,
+		"questions": questions,
+	})
+}
+
+// Get one question with every translation
+func getQuestionHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
+	questionID, ok := questionIDFromPath(w, r)
+	if !ok {
+		return
+	}
+	questions, err := loadQuestions(db, questionID, true)
+	if err != nil {
+		log.Println("Database query error:", err)
+		http.Error(w, "Failed to fetch question", http.StatusInternalServerError)
+		return
+	}
+	if len(questions) == 0 {
+		http.Error(w, "Question not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(questions[0])
+}
+
+// Add a question. It needs at least the English translation, and goes at the end of the
+// questionnaire unless display_order is given. It starts Retired unless the active risk model
+// already scores its question ID; set its status to Active once a model version that scores it is published.
+func createQuestionHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
+	var req struct {
+		DisplayOrder int                    `json:"display_order"`
+		ShowIf       *ShowIf                `json:"show_if"`
+		Translations map[string]Translation `json:"translations"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON request", http.StatusBadRequest)
+		return
+	}
+	if _, ok := req.Translations[sourceLanguage]; !ok {
+		http.Error(w, "An English translation is required", http.StatusBadRequest)
+		return
+	}
+	if req.ShowIf != nil {
+		questions, err := loadQuestions(db, 0, true)
+		if err != nil {
+			log.Println("Database query error:", err)
+			http.Error(w, "Failed to add question", http.StatusInternalServerError)
+			return
+		}
+		if err := validateShowIf(questions, 0, req.ShowIf); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	model, err := fetchActiveRiskModel()
+	if err != nil {
+		log.Println("Error fetching active risk model:", err)
+		http.Error(w, "Failed to check the active risk model", http.StatusBadGateway)
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		log.Println("Database transaction error:", err)
+		http.Error(w, "Failed to add question", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	if req.DisplayOrder <= 0 {
+		if err := tx.QueryRow("SELECT IFNULL(MAX(DisplayOrder), 0) + 1 FROM Questions").Scan(&req.DisplayOrder); err != nil {
+			log.Println("Database query error:", err)
+			http.Error(w, "Failed to add question", http.StatusInternalServerError)
+			return
+		}
+	}
+	result, err := tx.Exec("INSERT INTO Questions (DisplayOrder, Status, ShowIf) VALUES (?, 'Retired', ?)", req.DisplayOrder, showIfColumn(req.ShowIf))
+	if err != nil {
+		log.Println("Database insert error:", err)
+		http.Error(w, "Failed to add question", http.StatusInternalServerError)
+		return
+	}
+	questionID, _ := result.LastInsertId()
+
+	// Submissions would fail if the question were asked before the model scores it
+	if problem := model.activateProblem(int(questionID), req.ShowIf); problem == "" {
+		if _, err := tx.Exec("UPDATE Questions SET Status = 'Active' WHERE QuestionID = ?", questionID); err != nil {
+			log.Println("Database update error:", err)
+			http.Error(w, "Failed to add question", http.StatusInternalServerError)
+			return
+		}
+	} else {
+		log.Printf("Question %d added as Retired: %s\n", questionID, problem)
+	}
+
+	if err := saveTranslations(tx, int(questionID), 1, nil, req.Translations); err != nil {
+		writeQuestionSaveError(w, err)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		log.Println("Database commit error:", err)
+		http.Error(w, "Failed to add question", http.StatusInternalServerError)
+		return
+	}
+
+	caller, _ := auth.CallerFromContext(r.Context())
+	log.Printf("Question %d added by %d\n", questionID, caller.ID)
+	writeQuestion(w, db, int(questionID), http.StatusCreated)
+}
+
+// Change a question's order, status or translations. Only the fields given are changed, and
+// translations are added or replaced per language.
+func updateQuestionHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
+	questionID, ok := questionIDFromPath(w, r)
+	if !ok {
+		return
+	}
+	var req struct {
+		DisplayOrder *int                   `json:"display_order"`
+		Status       *string                `json:"status"`
+		ShowIf       json.RawMessage        `json:"show_if"` // null removes the branch rule
+		Translations map[string]Translation `json:"translations"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON request", http.StatusBadRequest)
+		return
+	}
+	if req.DisplayOrder != nil && *req.DisplayOrder <= 0 {
+		http.Error(w, "display_order must be positive", http.StatusBadRequest)
+		return
+	}
+	if req.Status != nil && *req.Status != questionActive && *req.Status != questionRetired {
+		http.Error(w, "status must be Active or Retired", http.StatusBadRequest)
+		return
+	}
+
+	questions, err := loadQuestions(db, 0, true)
+	if err != nil {
+		log.Println("Database query error:", err)
+		http.Error(w, "Failed to update question", http.StatusInternalServerError)
+		return
+	}
+	index := slices.IndexFunc(questions, func(q Question) bool { return q.QuestionID == questionID })
+	if index == -1 {
+		http.Error(w, "Question not found", http.StatusNotFound)
+		return
+	}
+	question := questions[index]
+
+	var showIf *ShowIf
+	if req.ShowIf != nil {
+		if err := json.Unmarshal(req.ShowIf, &showIf); err != nil {
+			http.Error(w, "show_if must be an object with question_id and answers, or null", http.StatusBadRequest)
+			return
+		}
+		if err := validateShowIf(questions, questionID, showIf); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	if req.Status != nil && *req.Status == questionRetired {
+		if dependents := dependentQuestions(questions, questionID); len(dependents) > 0 {
+			http.Error(w, retireBlockedMessage(questionID, dependents), http.StatusConflict)
+			return
+		}
+	}
+
+	// Status and branch changes to a question that is or becomes active have to agree with the
+	// active risk model
+	status, newShowIf := question.Status, question.ShowIf
+	if req.Status != nil {
+		status = *req.Status
+	}
+	if req.ShowIf != nil {
+		newShowIf = showIf
+	}
+	if (req.Status != nil || req.ShowIf != nil) && (status == questionActive || question.Status == questionActive) {
+		model, err := fetchActiveRiskModel()
+		if err != nil {
+			log.Println("Error fetching active risk model:", err)
+			http.Error(w, "Failed to check the active risk model", http.StatusBadGateway)
+			return
+		}
+		problem := model.activateProblem(questionID, newShowIf)
+		if status == questionRetired {
+			problem = model.retireProblem(questionID)
+		}
+		if problem != "" {
+			http.Error(w, problem, http.StatusConflict)
+			return
+		}
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		log.Println("Database transaction error:", err)
+		http.Error(w, "Failed to update question", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	if req.DisplayOrder != nil {
+		if _, err := tx.Exec("UPDATE Questions SET DisplayOrder = ? WHERE QuestionID = ?", *req.DisplayOrder, questionID); err != nil {
+			log.Println("Database update error:", err)
+			http.Error(w, "Failed to update question", http.StatusInternalServerError)
+			return
+		}
+	}
+	if req.Status != nil {
+		if _, err := tx.Exec("UPDATE Questions SET Status = ? WHERE QuestionID = ?", *req.Status, questionID); err != nil {
+			log.Println("Database update error:", err)
+			http.Error(w, "Failed to update question", http.StatusInternalServerError)
+			return
+		}
+	}
+	if req.ShowIf != nil {
+		if _, err := tx.Exec("UPDATE Questions SET ShowIf = ? WHERE QuestionID = ?", showIfColumn(showIf), questionID); err != nil {
+			log.Println("Database update error:", err)
+			http.Error(w, "Failed to update question", http.StatusInternalServerError)
+			return
+		}
+	}
+	if len(req.Translations) > 0 {
+		if err := saveTranslations(tx, questionID, question.Version, question.Translations, req.Translations); err != nil {
+			writeQuestionSaveError(w, err)
+			return
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		log.Println("Database commit error:", err)
+		http.Error(w, "Failed to update question", http.StatusInternalServerError)
+		return
+	}
+
+	caller, _ := auth.CallerFromContext(r.Context())
+	log.Printf("Question %d updated by %d\n", questionID, caller.ID)
+	writeQuestion(w, db, questionID, http.StatusOK)
+}
+
+// Retire a question so it's no longer asked. It stays in the bank, since past answers refer to
+// it, and can be brought back by setting its status to Active.
+func retireQuestionHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
+	questionID, ok := questionIDFromPath(w, r)
+	if !ok {
+		return
+	}
+	questions, err := loadQuestions(db, 0, false)
+	if err != nil {
+		log.Println("Database query error:", err)
+		http.Error(w, "Failed to retire question", http.StatusInternalServerError)
+		return
+	}
+	if dependents := dependentQuestions(questions, questionID); len(dependents) > 0 {
+		http.Error(w, retireBlockedMessage(questionID, dependents), http.StatusConflict)
+		return
+	}
+	model, err := fetchActiveRiskModel()
+	if err != nil {
+		log.Println("Error fetching active risk model:", err)
+		http.Error(w, "Failed to check the active risk model", http.StatusBadGateway)
+		return
+	}
+	if problem := model.retireProblem(questionID); problem != "" {
+		http.Error(w, problem, http.StatusConflict)
+		return
+	}
+
+	result, err := db.Exec("UPDATE Questions SET Status = 'Retired' WHERE QuestionID = ?", questionID)
+	if err != nil {
+		log.Println("Database update error:", err)
+		http.Error(w, "Failed to retire question", http.StatusInternalServerError)
+		return
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		var exists bool
+		db.QueryRow("SELECT COUNT(*) > 0 FROM Questions WHERE QuestionID = ?", questionID).Scan(&exists)
+		if !exists {
+			http.Error(w, "Question not found", http.StatusNotFound)
+			return
+		}
+	}
+
+	caller, _ := auth.CallerFromContext(r.Context())
+	log.Printf("Question %d retired by %d\n", questionID, caller.ID)
+	writeQuestion(w, db, questionID, http.StatusOK)
+}
+
+// Check that every active question is translated into every language, for its current version
+func translationCheckHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
+	questions, err := loadQuestions(db, 0, false)
+	if err != nil {
+		log.Println("Database query error:", err)
+		http.Error(w, "Failed to fetch questions", http.StatusInternalServerError)
+		return
+	}
+	issues := translationIssues(questions)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"complete":         len(issues) == 0,
+		"languages":        questionLanguages,
+		"active_questions": len(questions),
+		"issues":           issues,
+	})
+}
+
+func writeQuestionSaveError(w http.ResponseWriter, err error) {
+	var requestErr questionRequestError
+	if errors.As(err, &requestErr) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	log.Println("Failed to save question translations:", err)
+	http.Error(w, "Failed to save question", http.StatusInternalServerError)
+}
+
+// Value stored in Questions.ShowIf
+func showIfColumn(showIf *ShowIf) interface{} {
+	if showIf == nil {
+		return nil
+	}
+	data, _ := json.Marshal(showIf)
+	return string(data)
+}
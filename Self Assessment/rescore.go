@@ -0,0 +1,281 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"auth"
+)
+
+const batchScoringURL = "http://localhost:8080/api/analyzeRisk/batch"
+
+// A stored assessment and its recomputed result
+type rescoredAssessment struct {
+	AssessmentID         int
+	UserID               int
+	Answers              map[int]int
+	Language             string // Language the assessment was taken in, for the advice text
+	OriginalScore        int
+	OriginalRiskLevel    string
+	OriginalModelVersion int
+	Latest               bool // Most recent assessment for the patient
+
+	NewScore          int
+	NewRiskLevel      string
+	NewRecommendation string
+	NewModelVersion   int
+	Breakdown         json.RawMessage
+	TopFactors        json.RawMessage
+	Advice            json.RawMessage
+	Error             string
+}
+
+func (a *rescoredAssessment) changed() bool {
+	return a.NewScore != a.OriginalScore || a.NewRiskLevel != a.OriginalRiskLevel || a.NewModelVersion != a.OriginalModelVersion
+}
+
+// Re-score stored assessments with the current risk model:
+//
+//	self_assessment rescore [-dry-run] [-diff] [-model-version N] [-user N]
+//
+// Answers are replayed through the Risk Assessment service's batch endpoint. Changed rows are
+// updated and their previous result kept in AssessmentRescores. Patients whose latest
+// assessment newly becomes High are alerted to their care team.
+func runRescore(db *sql.DB, args []string) int {
+	flags := flag.NewFlagSet("rescore", flag.ContinueOnError)
+	dryRun := flags.Bool("dry-run", false, "compute and report changes without saving them or sending alerts")
+	diff := flags.Bool("diff", false, "print every changed assessment")
+	modelVersion := flags.Int("model-version", 0, "risk model version to score with (default: the active model)")
+	userID := flags.Int("user", 0, "only re-score this patient's assessments")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	assessments, err := loadAssessmentsForRescore(db, *userID)
+	if err != nil {
+		log.Println("Failed to load assessments:", err)
+		return 1
+	}
+	log.Printf("Re-scoring %d assessments\n", len(assessments))
+	if len(assessments) == 0 {
+		return 0
+	}
+
+	if err := scoreInBatch(assessments, *modelVersion); err != nil {
+		log.Println("Failed to re-score assessments:", err)
+		return 1
+	}
+
+	runID := time.Now().UTC().Format("20060102T150405Z")
+	changed, failed, newlyHigh := 0, 0, 0
+	for _, a := range assessments {
+		if a.Error != "" {
+			failed++
+			fmt.Printf("assessment %d (user %d): not re-scored: %s\n", a.AssessmentID, a.UserID, a.Error)
+			continue
+		}
+		if !a.changed() {
+			continue
+		}
+		changed++
+		becameHigh := a.Latest && a.NewRiskLevel == "High" && a.OriginalRiskLevel != "High"
+		if becameHigh {
+			newlyHigh++
+		}
+		if *diff {
+			fmt.Printf("assessment %d (user %d): %d %s (v%d) -> %d %s (v%d)\n", a.AssessmentID, a.UserID,
+				a.OriginalScore, a.OriginalRiskLevel, a.OriginalModelVersion, a.NewScore, a.NewRiskLevel, a.NewModelVersion)
+		}
+		if *dryRun {
+			continue
+		}
+
+		if err := saveRescore(db, runID, a); err != nil {
+			log.Printf("Failed to save re-score for assessment %d: %v\n", a.AssessmentID, err)
+			return 1
+		}
+		if becameHigh {
+			sendAlertToDoctors(a.UserID, int64(a.AssessmentID), fmt.Sprintf("Re-scored as High risk with model version %d", a.NewModelVersion))
+		}
+	}
+
+	mode := "saved as run " + runID
+	if *dryRun {
+		mode = "dry run, nothing saved"
+	}
+	fmt.Printf("%d assessments: %d changed, %d newly High, %d could not be scored (%s)\n", len(assessments), changed, newlyHigh, failed, mode)
+	return 0
+}
+
+// Load assessments with their stored answers and current result, oldest first
+func loadAssessmentsForRescore(db *sql.DB, userID int) ([]*rescoredAssessment, error) {
+	answerSets, err := loadAnswerSets(db, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `SELECT a.AssessmentID, a.UserID, IFNULL(a.TotalScore, 0), IFNULL(a.RiskLevel, ''), IFNULL(a.ModelVersion, 0),
+                     a.AssessmentID = (SELECT MAX(l.AssessmentID) FROM Assessments l WHERE l.UserID = a.UserID),
+                     IFNULL((SELECT MAX(x.Language) FROM AssessmentAnswers x WHERE x.AssessmentID = a.AssessmentID), '')
+              FROM Assessments a`
+	args := []interface{}{}
+	if userID > 0 {
+		query += " WHERE a.UserID = ?"
+		args = append(args, userID)
+	}
+	query += " ORDER BY a.AssessmentID"
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	assessments := []*rescoredAssessment{}
+	for rows.Next() {
+		a := &rescoredAssessment{}
+		if err := rows.Scan(&a.AssessmentID, &a.UserID, &a.OriginalScore, &a.OriginalRiskLevel, &a.OriginalModelVersion, &a.Latest, &a.Language); err != nil {
+			return nil, err
+		}
+		if a.Answers = answerSets[a.AssessmentID]; a.Answers == nil {
+			a.Error = "no stored answers"
+		}
+		assessments = append(assessments, a)
+	}
+	return assessments, rows.Err()
+}
+
+// Send the answers to the Risk Assessment service as one NDJSON batch and fill in the results
+func scoreInBatch(assessments []*rescoredAssessment, modelVersion int) error {
+	byID := map[int]*rescoredAssessment{}
+	var body bytes.Buffer
+	encoder := json.NewEncoder(&body)
+	for _, a := range assessments {
+		if a.Error != "" {
+			continue
+		}
+		byID[a.AssessmentID] = a
+		encoder.Encode(map[string]interface{}{
+			"id":            a.AssessmentID,
+			"user_id":       a.UserID,
+			"answers":       a.Answers,
+			"language":      a.Language,
+			"model_version": modelVersion,
+		})
+	}
+	if len(byID) == 0 {
+		return nil
+	}
+
+	token, err := auth.IssueServiceToken(jwtSecret, "self_assessment")
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, batchScoringURL, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("batch scoring returned status %d", resp.StatusCode)
+	}
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+	for scanner.Scan() {
+		var line struct {
+			ID     int    `json:"id"`
+			Error  string `json:"error"`
+			Errors []struct {
+				QuestionID int    `json:"question_id"`
+				Code       string `json:"code"`
+			} `json:"errors"`
+			Result *struct {
+				TotalScore     int             `json:"total_score"`
+				RiskLevel      string          `json:"risk_level"`
+				Recommendation string          `json:"recommendation"`
+				ModelVersion   int             `json:"model_version"`
+				Breakdown      json.RawMessage `json:"breakdown"`
+				TopFactors     json.RawMessage `json:"top_factors"`
+				Advice         json.RawMessage `json:"advice"`
+			} `json:"result"`
+		}
+		if err := json.Unmarshal(scanner.Bytes(), &line); err != nil {
+			return err
+		}
+		a, ok := byID[line.ID]
+		if !ok {
+			return fmt.Errorf("batch scoring: %s", line.Error)
+		}
+		delete(byID, line.ID)
+		if line.Result == nil {
+			a.Error = line.Error
+			for _, e := range line.Errors {
+				a.Error += fmt.Sprintf("; question %d: %s", e.QuestionID, e.Code)
+			}
+			continue
+		}
+		a.NewScore = line.Result.TotalScore
+		a.NewRiskLevel = line.Result.RiskLevel
+		a.NewRecommendation = line.Result.Recommendation
+		a.NewModelVersion = line.Result.ModelVersion
+		a.Breakdown = line.Result.Breakdown
+		a.TopFactors = line.Result.TopFactors
+		a.Advice = line.Result.Advice
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	for _, a := range byID {
+		a.Error = "no result returned"
+	}
+	return nil
+}
+
+// Keep the original result in AssessmentRescores and update the assessment with the new one
+func saveRescore(db *sql.DB, runID string, a *rescoredAssessment) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`INSERT INTO AssessmentRescores (RunID, AssessmentID, UserID, OriginalScore, OriginalRiskLevel, OriginalModelVersion, NewScore, NewRiskLevel, NewModelVersion)
+                      VALUES (?, ?, ?, ?, NULLIF(?, ''), NULLIF(?, 0), ?, ?, ?)`,
+		runID, a.AssessmentID, a.UserID, a.OriginalScore, a.OriginalRiskLevel, a.OriginalModelVersion, a.NewScore, a.NewRiskLevel, a.NewModelVersion)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`UPDATE Assessments SET TotalScore = ?, RiskLevel = ?, Recommendation = ?, ModelVersion = ?, ScoreBreakdown = ?, TopFactors = ?, Advice = ?
+                      WHERE AssessmentID = ?`,
+		a.NewScore, a.NewRiskLevel, a.NewRecommendation, a.NewModelVersion, string(a.Breakdown), string(a.TopFactors), string(a.Advice), a.AssessmentID)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Run a command instead of the server, e.g. `self_assessment rescore -dry-run`, and exit
+func runCommand(db *sql.DB, args []string) {
+	switch args[0] {
+	case "rescore":
+		os.Exit(runRescore(db, args[1:]))
+	default:
+		log.Fatalf("Unknown command %q", args[0])
+	}
+}
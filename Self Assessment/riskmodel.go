@@ -0,0 +1,73 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// Submissions send the answer to every active question to the Risk Assessment service, which
+// rejects questions its active model doesn't score and requires its non-optional ones. The
+// question bank is checked against that model before a question is activated or retired.
+const activeRiskModelURL = "http://localhost:8080/api/riskModels/active"
+
+// Scoring rule for one question in the active model
+type modelQuestion struct {
+	ID       int  `json:"id"`
+	Optional bool `json:"optional"`
+}
+
+// The parts of the active risk model the question bank has to agree with
+type activeRiskModel struct {
+	Version   int             `json:"version"`
+	Questions []modelQuestion `json:"questions"`
+}
+
+// Fetch the model the Risk Assessment service currently scores with
+func fetchActiveRiskModel() (*activeRiskModel, error) {
+	resp, err := http.Get(activeRiskModelURL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("risk model lookup returned status %d", resp.StatusCode)
+	}
+
+	var model activeRiskModel
+	if err := json.NewDecoder(resp.Body).Decode(&model); err != nil {
+		return nil, err
+	}
+	return &model, nil
+}
+
+func (m *activeRiskModel) question(questionID int) (modelQuestion, bool) {
+	for _, q := range m.Questions {
+		if q.ID == questionID {
+			return q, true
+		}
+	}
+	return modelQuestion{}, false
+}
+
+// Why a question can't be active under this model, or "" if it can. The model has to score it,
+// and a question with a branch rule has to be optional, since it's left out when not asked.
+func (m *activeRiskModel) activateProblem(questionID int, showIf *ShowIf) string {
+	q, ok := m.question(questionID)
+	if !ok {
+		return fmt.Sprintf("Risk model version %d doesn't score question %d; publish a model version that includes it first", m.Version, questionID)
+	}
+	if showIf != nil && !q.Optional {
+		return fmt.Sprintf("Question %d is only asked on some answers to question %d, so risk model version %d must mark it optional; publish a model version that does first",
+			questionID, showIf.QuestionID, m.Version)
+	}
+	return ""
+}
+
+// Why a question can't be retired under this model, or "" if it can
+func (m *activeRiskModel) retireProblem(questionID int) string {
+	if q, ok := m.question(questionID); ok && !q.Optional {
+		return fmt.Sprintf("Risk model version %d requires an answer to question %d; publish a model version that makes it optional or drops it first", m.Version, questionID)
+	}
+	return ""
+}
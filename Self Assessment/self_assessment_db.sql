@@ -7,91 +7,154 @@ CREATE TABLE Assessments (
     AssessmentID INT AUTO_INCREMENT PRIMARY KEY,
     UserID INT NOT NULL,
     DateCreated TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-    QuestionResponses TEXT NOT NULL,
+    QuestionResponses TEXT NULL, -- Legacy JSON answers; moved into AssessmentAnswers (and cleared) when the service starts
     TotalScore INT,
     RiskLevel ENUM('Low', 'Moderate', 'High'),
-    Recommendation TEXT
+    Recommendation TEXT,
+    ModelVersion INT NULL, -- Risk model version that produced TotalScore/RiskLevel; NULL for rows scored before versioning
+    ScoreBreakdown TEXT NULL, -- JSON list of per-question contributions from the risk service
+    TopFactors TEXT NULL, -- JSON list of the factors that added the most risk
+    Advice TEXT NULL, -- JSON list of targeted advice, in the language the assessment was taken in
+    INDEX (UserID, DateCreated) -- Assessment history, one page at a time
 );
 
--- English Questions
-CREATE TABLE QuestionsEn (
-    QuestionID INT AUTO_INCREMENT PRIMARY KEY,
-    QuestionContent VARCHAR(500) NOT NULL, 
-    QuestionOptions VARCHAR(500) NOT NULL 
+-- One row per answered question. Language and QuestionVersion are NULL for answers migrated from QuestionResponses.
+CREATE TABLE AssessmentAnswers (
+    AssessmentID INT NOT NULL,
+    QuestionID INT NOT NULL,
+    OptionIndex INT NOT NULL, -- 1-based index into the question's options
+    Language VARCHAR(20) NULL, -- Language the questionnaire was taken in
+    QuestionVersion INT NULL, -- Questions.Version when it was answered
+    PRIMARY KEY (AssessmentID, QuestionID),
+    INDEX (QuestionID, OptionIndex),
+    FOREIGN KEY (AssessmentID) REFERENCES Assessments(AssessmentID) ON DELETE CASCADE
 );
 
--- Chinese (Simplified) Questions
-CREATE TABLE QuestionsCn (
-    QuestionID INT AUTO_INCREMENT PRIMARY KEY,
-    QuestionContent VARCHAR(500) NOT NULL, 
-    QuestionOptions VARCHAR(500) NOT NULL 
+-- Results replaced when assessments are re-scored with a new risk model (`self_assessment rescore`)
+CREATE TABLE AssessmentRescores (
+    RescoreID INT AUTO_INCREMENT PRIMARY KEY,
+    RunID VARCHAR(32) NOT NULL, -- Groups the rows changed by one rescore run
+    AssessmentID INT NOT NULL,
+    UserID INT NOT NULL,
+    OriginalScore INT,
+    OriginalRiskLevel ENUM('Low', 'Moderate', 'High') NULL,
+    OriginalModelVersion INT NULL,
+    NewScore INT NOT NULL,
+    NewRiskLevel ENUM('Low', 'Moderate', 'High') NOT NULL,
+    NewModelVersion INT NOT NULL,
+    RescoredAt TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (AssessmentID) REFERENCES Assessments(AssessmentID) ON DELETE CASCADE
 );
 
--- Malay Questions
-CREATE TABLE QuestionsMy (
-    QuestionID INT AUTO_INCREMENT PRIMARY KEY,
-    QuestionContent VARCHAR(500) NOT NULL, 
-    QuestionOptions VARCHAR(500) NOT NULL 
+-- Answers saved part-way through the questionnaire, one per patient. Expire 14 days after UpdatedAt.
+CREATE TABLE AssessmentDrafts (
+    UserID INT PRIMARY KEY,
+    Answers TEXT NOT NULL, -- JSON {question_id: option}, possibly incomplete
+    Language VARCHAR(20) NULL,
+    CurrentQuestionID INT NULL, -- Question the patient was on
+    UpdatedAt TIMESTAMP DEFAULT CURRENT_TIMESTAMP
 );
 
--- Tamil Questions
-CREATE TABLE QuestionsTa (
+-- Question bank. QuestionIDs are stable: answers and risk models refer to them, so questions are
+-- retired rather than deleted. Version goes up whenever the English wording or options change.
+CREATE TABLE Questions (
     QuestionID INT AUTO_INCREMENT PRIMARY KEY,
-    QuestionContent VARCHAR(500) NOT NULL, 
-    QuestionOptions VARCHAR(500) NOT NULL 
+    DisplayOrder INT NOT NULL,
+    Status ENUM('Active', 'Retired') NOT NULL DEFAULT 'Active',
+    Version INT NOT NULL DEFAULT 1,
+    ShowIf TEXT NULL, -- JSON branch rule {"question_id": N, "answers": [...]}: only asked when question N got one of these answers
+    CreatedAt TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UpdatedAt TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP
+);
+
+-- Wording and options of a question in one language (English, Chinese, Malay or Tamil)
+CREATE TABLE QuestionTranslations (
+    QuestionID INT NOT NULL,
+    Language VARCHAR(20) NOT NULL,
+    QuestionContent VARCHAR(500) NOT NULL,
+    QuestionOptions VARCHAR(500) NOT NULL, -- JSON list of option labels, in option order
+    Version INT NOT NULL, -- Question version this translation was written for
+    PRIMARY KEY (QuestionID, Language),
+    FOREIGN KEY (QuestionID) REFERENCES Questions(QuestionID)
 );
 
 
+-- Sample assessments in the legacy QuestionResponses format; the service moves them into AssessmentAnswers on startup
 INSERT INTO Assessments (UserID, QuestionResponses, TotalScore, RiskLevel, Recommendation) VALUES
 (1, '{ 1: 2, 2: 3, 3: 2, 4: 1, 5: 3, 6: 2, 7: 2, 8: 3, 9: 2, 10: 1 }', 15, 'Moderate', 'Consider physical therapy, improve home safety, and monitor medications.'),
 (1, '{ 1: 2, 2: 2, 3: 1, 4: 2, 5: 1, 6: 2, 7: 2, 8: 2, 9: 2, 10: 1 }', 8, 'Low', 'Maintain a healthy lifestyle and exercise regularly.'),
 (2, '{ 1: 1, 2: 1, 3: 2, 4: 4, 5: 3, 6: 1, 7: 2, 8: 2, 9: 1, 10: 3 }', 18, 'High', 'Consult a healthcare provider for a fall risk assessment and use mobility aids.');
 
-INSERT INTO QuestionsEn (QuestionContent, QuestionOptions) 
-VALUES 
-("Do you experience dizziness?", '["Yes", "No"]'),
-("How is your balance?", '["Good", "Moderate", "Poor"]'),
-("How many times have you fallen in the past year?", '["0", "1-2", "3 or more"]'),
-("Do you use any mobility aids?", '["None", "Cane", "Walker", "Wheelchair"]'),
-("Do you feel unsteady when walking?", '["Never", "Sometimes", "Often", "Always"]'),
-("Have you had a fall in the past 6 months?", '["Yes", "No"]'),
-("Are you able to stand up from a chair without using your hands?", '["Yes", "No"]'),
-("Do you take medications that cause dizziness?", '["Yes", "No", "Not sure"]'),
-("Do you exercise regularly?", '["Yes", "No"]'),
-("Do you experience numbness in your feet?", '["Yes", "No", "Sometimes"]');
-INSERT INTO QuestionsCn (QuestionContent, QuestionOptions) 
-VALUES 
-("你是否感到头晕？", '["是", "否"]'),
-("你的平衡能力如何？", '["良好", "一般", "差"]'),
-("过去一年内你跌倒过几次？", '["0", "1-2", "3次或更多"]'),
-("你使用助行器具吗？", '["无", "手杖", "助行器", "轮椅"]'),
-("走路时你会感到不稳吗？", '["从不", "有时", "经常", "总是"]'),
-("过去6个月内你是否跌倒过？", '["是", "否"]'),
-("你能不用手站起来吗？", '["是", "否"]'),
-("你是否服用会导致头晕的药物？", '["是", "否", "不确定"]'),
-("你是否定期运动？", '["是", "否"]'),
-("你的脚是否会感到麻木？", '["是", "否", "有时"]');
-INSERT INTO QuestionsMy (QuestionContent, QuestionOptions) 
-VALUES 
-("Adakah anda mengalami pening?", '["Ya", "Tidak"]'),
-("Bagaimanakah keseimbangan anda?", '["Baik", "Sederhana", "Buruk"]'),
-("Berapa kali anda terjatuh dalam setahun yang lalu?", '["0", "1-2", "3 atau lebih"]'),
-("Adakah anda menggunakan alat bantuan pergerakan?", '["Tiada", "Tongkat", "Walker", "Kerusi roda"]'),
-("Adakah anda berasa tidak stabil semasa berjalan?", '["Tidak pernah", "Kadang-kadang", "Selalu", "Setiap masa"]'),
-("Adakah anda pernah jatuh dalam 6 bulan terakhir?", '["Ya", "Tidak"]'),
-("Bolehkah anda bangun dari kerusi tanpa menggunakan tangan?", '["Ya", "Tidak"]'),
-("Adakah anda mengambil ubat yang menyebabkan pening?", '["Ya", "Tidak", "Tidak pasti"]'),
-("Adakah anda bersenam secara berkala?", '["Ya", "Tidak"]'),
-("Adakah anda mengalami kebas di kaki anda?", '["Ya", "Tidak", "Kadang-kadang"]');
-INSERT INTO QuestionsTa (QuestionContent, QuestionOptions) 
-VALUES 
-("நீங்கள் மயக்கம் உணர்கிறீர்களா?", '["ஆம்", "இல்லை"]'),
-("உங்கள் சமநிலை எப்படி உள்ளது?", '["நல்லது", "மிதமானது", "மோசமானது"]'),
-("கடந்த ஆண்டு நீங்கள் எத்தனை முறை கீழே விழுந்தீர்கள்?", '["0", "1-2", "3 அல்லது அதற்கு மேல்"]'),
-("நீங்கள் நகர்வதற்கு உதவிகள் பயன்படுத்துகிறீர்களா?", '["இல்லை", "சங்கில்", "நடக்க உதவும் கருவி", "சக்கர நாற்காலி"]'),
-("நடக்கும் போது நீங்கள் நிலை தடுமாறுகிறீர்களா?", '["ஒருபோதும் இல்லை", "சில சமயங்களில்", "அடிக்கடி", "எப்போதும்"]'),
-("கடந்த 6 மாதங்களில் நீங்கள் கீழே விழுந்தீர்களா?", '["ஆம்", "இல்லை"]'),
-("நீங்கள் கைகளைப் பயன்படுத்தாமல் நாற்காலியில் இருந்து எழுந்திருக்க முடியுமா?", '["ஆம்", "இல்லை"]'),
-("நீங்கள் மயக்கத்தை ஏற்படுத்தும் மருந்துகளை எடுத்துக்கொள்கிறீர்களா?", '["ஆம்", "இல்லை", "தெரியாது"]'),
-("நீங்கள் முறையாக உடற்பயிற்சி செய்கிறீர்களா?", '["ஆம்", "இல்லை"]'),
-("உங்கள் கால்களில் உணர்விழப்பு இருக்கிறதா?", '["ஆம்", "இல்லை", "சில சமயங்களில்"]');
\ No newline at end of file
+-- Question 6 is only asked after reporting a fall in the past year, and the medication details
+-- (11 and 12) only to people taking medications that cause dizziness
+INSERT INTO Questions (QuestionID, DisplayOrder, ShowIf)
+VALUES
+(1, 10, NULL),
+(2, 20, NULL),
+(3, 30, NULL),
+(4, 40, NULL),
+(5, 50, NULL),
+(6, 60, '{"question_id": 3, "answers": [2, 3]}'),
+(7, 70, NULL),
+(8, 80, NULL),
+(9, 90, NULL),
+(10, 100, NULL),
+(11, 81, '{"question_id": 8, "answers": [1]}'),
+(12, 82, '{"question_id": 8, "answers": [1]}');
+
+INSERT INTO QuestionTranslations (QuestionID, Language, QuestionContent, QuestionOptions, Version)
+VALUES
+(1, 'English', "Do you experience dizziness?", '["Yes", "No"]', 1),
+(2, 'English', "How is your balance?", '["Good", "Moderate", "Poor"]', 1),
+(3, 'English', "How many times have you fallen in the past year?", '["0", "1-2", "3 or more"]', 1),
+(4, 'English', "Do you use any mobility aids?", '["None", "Cane", "Walker", "Wheelchair"]', 1),
+(5, 'English', "Do you feel unsteady when walking?", '["Never", "Sometimes", "Often", "Always"]', 1),
+(6, 'English', "Have you had a fall in the past 6 months?", '["Yes", "No"]', 1),
+(7, 'English', "Are you able to stand up from a chair without using your hands?", '["Yes", "No"]', 1),
+(8, 'English', "Do you take medications that cause dizziness?", '["Yes", "No", "Not sure"]', 1),
+(9, 'English', "Do you exercise regularly?", '["Yes", "No"]', 1),
+(10, 'English', "Do you experience numbness in your feet?", '["Yes", "No", "Sometimes"]', 1),
+(11, 'English', "How many medications that can cause dizziness do you take?", '["1", "2", "3 or more"]', 1),
+(12, 'English', "Has a doctor or pharmacist reviewed these medications in the past year?", '["Yes", "No"]', 1);
+INSERT INTO QuestionTranslations (QuestionID, Language, QuestionContent, QuestionOptions, Version)
+VALUES
+(1, 'Chinese', "你是否感到头晕？", '["是", "否"]', 1),
+(2, 'Chinese', "你的平衡能力如何？", '["良好", "一般", "差"]', 1),
+(3, 'Chinese', "过去一年内你跌倒过几次？", '["0", "1-2", "3次或更多"]', 1),
+(4, 'Chinese', "你使用助行器具吗？", '["无", "手杖", "助行器", "轮椅"]', 1),
+(5, 'Chinese', "走路时你会感到不稳吗？", '["从不", "有时", "经常", "总是"]', 1),
+(6, 'Chinese', "过去6个月内你是否跌倒过？", '["是", "否"]', 1),
+(7, 'Chinese', "你能不用手站起来吗？", '["是", "否"]', 1),
+(8, 'Chinese', "你是否服用会导致头晕的药物？", '["是", "否", "不确定"]', 1),
+(9, 'Chinese', "你是否定期运动？", '["是", "否"]', 1),
+(10, 'Chinese', "你的脚是否会感到麻木？", '["是", "否", "有时"]', 1),
+(11, 'Chinese', "你服用多少种可能导致头晕的药物？", '["1", "2", "3种或更多"]', 1),
+(12, 'Chinese', "过去一年内，医生或药剂师是否检查过这些药物？", '["是", "否"]', 1);
+INSERT INTO QuestionTranslations (QuestionID, Language, QuestionContent, QuestionOptions, Version)
+VALUES
+(1, 'Malay', "Adakah anda mengalami pening?", '["Ya", "Tidak"]', 1),
+(2, 'Malay', "Bagaimanakah keseimbangan anda?", '["Baik", "Sederhana", "Buruk"]', 1),
+(3, 'Malay', "Berapa kali anda terjatuh dalam setahun yang lalu?", '["0", "1-2", "3 atau lebih"]', 1),
+(4, 'Malay', "Adakah anda menggunakan alat bantuan pergerakan?", '["Tiada", "Tongkat", "Walker", "Kerusi roda"]', 1),
+(5, 'Malay', "Adakah anda berasa tidak stabil semasa berjalan?", '["Tidak pernah", "Kadang-kadang", "Selalu", "Setiap masa"]', 1),
+(6, 'Malay', "Adakah anda pernah jatuh dalam 6 bulan terakhir?", '["Ya", "Tidak"]', 1),
+(7, 'Malay', "Bolehkah anda bangun dari kerusi tanpa menggunakan tangan?", '["Ya", "Tidak"]', 1),
+(8, 'Malay', "Adakah anda mengambil ubat yang menyebabkan pening?", '["Ya", "Tidak", "Tidak pasti"]', 1),
+(9, 'Malay', "Adakah anda bersenam secara berkala?", '["Ya", "Tidak"]', 1),
+(10, 'Malay', "Adakah anda mengalami kebas di kaki anda?", '["Ya", "Tidak", "Kadang-kadang"]', 1),
+(11, 'Malay', "Berapa jenis ubat yang boleh menyebabkan pening yang anda ambil?", '["1", "2", "3 atau lebih"]', 1),
+(12, 'Malay', "Adakah doktor atau ahli farmasi telah menyemak ubat-ubatan ini dalam setahun yang lalu?", '["Ya", "Tidak"]', 1);
+INSERT INTO QuestionTranslations (QuestionID, Language, QuestionContent, QuestionOptions, Version)
+VALUES
+(1, 'Tamil', "நீங்கள் மயக்கம் உணர்கிறீர்களா?", '["ஆம்", "இல்லை"]', 1),
+(2, 'Tamil', "உங்கள் சமநிலை எப்படி உள்ளது?", '["நல்லது", "மிதமானது", "மோசமானது"]', 1),
+(3, 'Tamil', "கடந்த ஆண்டு நீங்கள் எத்தனை முறை கீழே விழுந்தீர்கள்?", '["0", "1-2", "3 அல்லது அதற்கு மேல்"]', 1),
+(4, 'Tamil', "நீங்கள் நகர்வதற்கு உதவிகள் பயன்படுத்துகிறீர்களா?", '["இல்லை", "சங்கில்", "நடக்க உதவும் கருவி", "சக்கர நாற்காலி"]', 1),
+(5, 'Tamil', "நடக்கும் போது நீங்கள் நிலை தடுமாறுகிறீர்களா?", '["ஒருபோதும் இல்லை", "சில சமயங்களில்", "அடிக்கடி", "எப்போதும்"]', 1),
+(6, 'Tamil', "கடந்த 6 மாதங்களில் நீங்கள் கீழே விழுந்தீர்களா?", '["ஆம்", "இல்லை"]', 1),
+(7, 'Tamil', "நீங்கள் கைகளைப் பயன்படுத்தாமல் நாற்காலியில் இருந்து எழுந்திருக்க முடியுமா?", '["ஆம்", "இல்லை"]', 1),
+(8, 'Tamil', "நீங்கள் மயக்கத்தை ஏற்படுத்தும் மருந்துகளை எடுத்துக்கொள்கிறீர்களா?", '["ஆம்", "இல்லை", "தெரியாது"]', 1),
+(9, 'Tamil', "நீங்கள் முறையாக உடற்பயிற்சி செய்கிறீர்களா?", '["ஆம்", "இல்லை"]', 1),
+(10, 'Tamil', "உங்கள் கால்களில் உணர்விழப்பு இருக்கிறதா?", '["ஆம்", "இல்லை", "சில சமயங்களில்"]', 1),
+(11, 'Tamil', "மயக்கத்தை ஏற்படுத்தக்கூடிய எத்தனை மருந்துகளை நீங்கள் எடுத்துக்கொள்கிறீர்கள்?", '["1", "2", "3 அல்லது அதற்கு மேல்"]', 1),
+(12, 'Tamil', "கடந்த ஆண்டில் மருத்துவர் அல்லது மருந்தாளர் இந்த மருந்துகளை மதிப்பாய்வு செய்தாரா?", '["ஆம்", "இல்லை"]', 1);
\ No newline at end of file
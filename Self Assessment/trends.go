@@ -0,0 +1,224 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+
+	"auth"
+)
+
+// Deterioration rules. A new assessment is flagged when its risk level is worse than the previous
+// one, or when its score is at least jumpThreshold points above any score in the preceding window.
+const (
+	defaultJumpThreshold = 3
+	defaultJumpWindow    = 30 // days
+	movingAverageWindow  = 3  // assessments
+)
+
+var riskLevelRank = map[string]int{"Low": 0, "Moderate": 1, "High": 2}
+
+// One assessment in a patient's score history, oldest first
+type TrendPoint struct {
+	AssessmentID  int      `json:"assessment_id"`
+	DateCreated   string   `json:"date_created"`
+	TotalScore    int      `json:"total_score"`
+	RiskLevel     string   `json:"risk_level"`
+	Delta         *int     `json:"delta"`          // Change since the previous assessment
+	MovingAverage float64  `json:"moving_average"` // Of this and up to two earlier scores
+	Deterioration []string `json:"deterioration,omitempty"`
+
+	date time.Time
+}
+
+// Load a patient's assessment scores, oldest first
+func loadTrendPoints(db *sql.DB, userID int) ([]TrendPoint, error) {
+	query := `SELECT AssessmentID, DateCreated, IFNULL(TotalScore, 0), IFNULL(RiskLevel, '')
+              FROM Assessments
+              WHERE UserID = ?
+              ORDER BY DateCreated, AssessmentID`
+	rows, err := db.Query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	points := []TrendPoint{}
+	for rows.Next() {
+		var p TrendPoint
+		if err := rows.Scan(&p.AssessmentID, &p.date, &p.TotalScore, &p.RiskLevel); err != nil {
+			return nil, err
+		}
+		p.DateCreated = p.date.Format("2006-01-02 15:04:05")
+		points = append(points, p)
+	}
+	return points, rows.Err()
+}
+
+// Fill in deltas, moving averages and deterioration flags, and return the least-squares slope
+// of score against time in points per 30 days
+func analyzeTrend(points []TrendPoint, jumpThreshold, windowDays int) float64 {
+	for i := range points {
+		p := &points[i]
+
+		sum := 0
+		start := max(0, i-movingAverageWindow+1)
+		for _, earlier := range points[start : i+1] {
+			sum += earlier.TotalScore
+		}
+		p.MovingAverage = math.Round(float64(sum)/float64(i+1-start)*100) / 100
+
+		if i == 0 {
+			continue
+		}
+		previous := points[i-1]
+		delta := p.TotalScore - previous.TotalScore
+		p.Delta = &delta
+		p.Deterioration = deteriorationReasons(points[:i+1], jumpThreshold, windowDays)
+	}
+
+	if len(points) < 2 {
+		return 0
+	}
+	first := points[0].date
+	var n, sumX, sumY, sumXY, sumXX float64
+	for _, p := range points {
+		x := p.date.Sub(first).Hours() / 24
+		y := float64(p.TotalScore)
+		n++
+		sumX += x
+		sumY += y
+		sumXY += x * y
+		sumXX += x * x
+	}
+	denominator := n*sumXX - sumX*sumX
+	if denominator == 0 {
+		return 0 // All assessments on the same day
+	}
+	slope := (n*sumXY - sumX*sumY) / denominator
+	return math.Round(slope*30*100) / 100
+}
+
+// Reasons the last assessment in history counts as a deterioration, if any
+func deteriorationReasons(history []TrendPoint, jumpThreshold, windowDays int) []string {
+	if len(history) < 2 {
+		return nil
+	}
+	latest := history[len(history)-1]
+	previous := history[len(history)-2]
+	reasons := []string{}
+
+	latestRank, ok1 := riskLevelRank[latest.RiskLevel]
+	previousRank, ok2 := riskLevelRank[previous.RiskLevel]
+	if ok1 && ok2 && latestRank > previousRank {
+		reasons = append(reasons, fmt.Sprintf("Risk level rose from %s to %s", previous.RiskLevel, latest.RiskLevel))
+	}
+
+	// Compare against the lowest score in the window so a steady climb is caught too
+	windowStart := latest.date.AddDate(0, 0, -windowDays)
+	lowest, found := 0, false
+	for _, p := range history[:len(history)-1] {
+		if p.date.Before(windowStart) {
+			continue
+		}
+		if !found || p.TotalScore < lowest {
+			lowest, found = p.TotalScore, true
+		}
+	}
+	if found && latest.TotalScore-lowest >= jumpThreshold {
+		reasons = append(reasons, fmt.Sprintf("Score rose by %d points within %d days (from %d to %d)", latest.TotalScore-lowest, windowDays, lowest, latest.TotalScore))
+	}
+	return reasons
+}
+
+// Score trend across a patient's assessments
+func assessmentTrendHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
+	type Request struct {
+		UserID        int `json:"user_id"`
+		JumpThreshold int `json:"jump_threshold"` // Optional, points
+		WindowDays    int `json:"window_days"`    // Optional
+	}
+	var req Request
+
+	// Decode JSON request (optional for patients, who always get their own records)
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			log.Println("Invalid JSON request")
+			http.Error(w, "Invalid JSON request", http.StatusBadRequest)
+			return
+		}
+	}
+	if req.JumpThreshold < 0 || req.WindowDays < 0 {
+		http.Error(w, "jump_threshold and window_days must be positive", http.StatusBadRequest)
+		return
+	}
+	if req.JumpThreshold == 0 {
+		req.JumpThreshold = defaultJumpThreshold
+	}
+	if req.WindowDays == 0 {
+		req.WindowDays = defaultJumpWindow
+	}
+
+	// Resolve and authorise the patient from the access token
+	userID, ok := auth.PatientID(w, r, req.UserID)
+	if !ok {
+		return
+	}
+
+	points, err := loadTrendPoints(db, userID)
+	if err != nil {
+		log.Println("Database query error:", err)
+		http.Error(w, "Failed to fetch data", http.StatusInternalServerError)
+		return
+	}
+	if len(points) == 0 {
+		http.Error(w, "No risk assessments found for this user", http.StatusNotFound)
+		return
+	}
+
+	slope := analyzeTrend(points, req.JumpThreshold, req.WindowDays)
+	latest := points[len(points)-1]
+	deterioration := latest.Deterioration
+	if deterioration == nil {
+		deterioration = []string{}
+	}
+
+	response := map[string]interface{}{
+		"user_id":               userID,
+		"assessments":           points,
+		"count":                 len(points),
+		"overall_change":        latest.TotalScore - points[0].TotalScore,
+		"slope_per_30_days":     slope,
+		"moving_average_window": movingAverageWindow,
+		"jump_threshold":        req.JumpThreshold,
+		"window_days":           req.WindowDays,
+		"deteriorating":         len(deterioration) > 0,
+		"deterioration":         deterioration,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// Alert the care team when a new assessment shows deterioration that its risk level alone
+// wouldn't raise an alert for. Runs after the assessment has been stored.
+func checkDeterioration(db *sql.DB, userID int, assessmentID int64) {
+	points, err := loadTrendPoints(db, userID)
+	if err != nil {
+		log.Println("Failed to load assessment history:", err)
+		return
+	}
+	if len(points) == 0 || int64(points[len(points)-1].AssessmentID) != assessmentID {
+		return
+	}
+
+	reasons := deteriorationReasons(points, defaultJumpThreshold, defaultJumpWindow)
+	if len(reasons) > 0 {
+		sendAlertToDoctors(userID, assessmentID, "Deteriorating: "+strings.Join(reasons, "; "))
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"auth"
+)
+
+// Full assessment record, including the raw answers, for personal data exports
+type AssessmentExport struct {
+	AssessmentID      int         `json:"id"`
+	DateCreated       string      `json:"dateCreated"`
+	QuestionResponses map[int]int `json:"questionResponses"` // {question_id: option}
+	TotalScore        int         `json:"totalScore"`
+	RiskLevel         string      `json:"riskLevel"`
+	Recommendation    string      `json:"recommendation"`
+	ModelVersion      int         `json:"modelVersion,omitempty"`
+	ScoreBreakdown    string      `json:"scoreBreakdown,omitempty"`
+	Advice            string      `json:"advice,omitempty"`
+}
+
+// Return every assessment stored for the authenticated patient. Called by the User service's data export.
+func exportUserDataHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
+	caller, _ := auth.CallerFromContext(r.Context())
+
+	answerSets, err := loadAnswerSets(db, caller.ID)
+	if err != nil {
+		log.Println("Database query error:", err)
+		http.Error(w, "Failed to fetch data", http.StatusInternalServerError)
+		return
+	}
+
+	query := `SELECT AssessmentID, DateCreated, IFNULL(TotalScore, 0), IFNULL(RiskLevel, ''), IFNULL(Recommendation, ''), IFNULL(ModelVersion, 0), IFNULL(ScoreBreakdown, ''), IFNULL(Advice, '')
+              FROM Assessments
+              WHERE UserID = ?
+              ORDER BY DateCreated`
+	rows, err := db.Query(query, caller.ID)
+	if err != nil {
+		log.Println("Database query error:", err)
+		http.Error(w, "Failed to fetch data", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	assessments := []AssessmentExport{}
+	for rows.Next() {
+		var a AssessmentExport
+		var dateCreated time.Time
+		if err := rows.Scan(&a.AssessmentID, &dateCreated, &a.TotalScore, &a.RiskLevel, &a.Recommendation, &a.ModelVersion, &a.ScoreBreakdown, &a.Advice); err != nil {
+			log.Println("Error scanning row:", err)
+			http.Error(w, "Failed to process data", http.StatusInternalServerError)
+			return
+		}
+		a.DateCreated = dateCreated.Format("2006-01-02 15:04:05")
+		a.QuestionResponses = answerSets[a.AssessmentID]
+		assessments = append(assessments, a)
+	}
+
+	// Unfinished questionnaire, if any
+	draft, err := loadDraft(db, caller.ID)
+	if err != nil {
+		log.Println("Database query error:", err)
+		http.Error(w, "Failed to fetch data", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"assessments": assessments, "draft": draft})
+}
+
+// Delete every assessment and draft stored for the authenticated patient. Called by the User service when an account is deleted.
+func deleteUserDataHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
+	caller, _ := auth.CallerFromContext(r.Context())
+
+	result, err := db.Exec("DELETE FROM Assessments WHERE UserID = ?", caller.ID)
+	if err != nil {
+		log.Println("Database delete error:", err)
+		http.Error(w, "Failed to delete data", http.StatusInternalServerError)
+		return
+	}
+	deleted, _ := result.RowsAffected()
+	if _, err := db.Exec("DELETE FROM AssessmentDrafts WHERE UserID = ?", caller.ID); err != nil {
+		log.Println("Database delete error:", err)
+		http.Error(w, "Failed to delete data", http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("Deleted %d assessments for user %d\n", deleted, caller.ID)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"message": "Assessment data deleted", "deleted": deleted})
+}
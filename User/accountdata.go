@@ -0,0 +1,230 @@
+package main
+
+import (
+	"archive/zip"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"auth"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Services holding patient data. Each is called with the patient's own access token, so it
+// only ever returns or deletes that patient's records.
+var userDataServices = []struct {
+	Name         string
+	ExportMethod string
+	ExportURL    string
+	DeleteURL    string
+}{
+	{"assessments", http.MethodGet, "http://localhost:5000/api/exportUserData", "http://localhost:5000/api/deleteUserData"},
+	{"notifications", http.MethodGet, "http://localhost:5002/api/exportUserData", "http://localhost:5002/api/deleteUserData"},
+	{"vision_results", http.MethodGet, "http://localhost:8088/exportUserData", "http://localhost:8088/deleteUserData"},
+	{"care_team", http.MethodPost, "http://localhost:5004/api/getCareTeam", "http://localhost:5004/api/deleteUserData"},
+}
+
+var serviceClient = &http.Client{Timeout: 10 * time.Second}
+
+// Call another service on the patient's behalf and return its JSON response
+func callUserDataService(method, url, token string) (json.RawMessage, error) {
+	req, err := http.NewRequest(method, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	resp, err := serviceClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s %s returned status %d", method, url, resp.StatusCode)
+	}
+	return body, nil
+}
+
+// Download everything stored about the authenticated patient across all services, as one
+// JSON document or, with ?format=zip, a ZIP archive holding one JSON file per service
+func exportMyDataHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
+	caller, _ := auth.CallerFromContext(r.Context())
+	format := r.URL.Query().Get("format")
+	if format != "" && format != "json" && format != "zip" {
+		http.Error(w, `{"message":"format must be json or zip"}`, http.StatusBadRequest)
+		return
+	}
+
+	var profile struct {
+		UserID        int       `json:"user_id"`
+		Name          string    `json:"name"`
+		Email         string    `json:"email"`
+		DateOfBirth   time.Time `json:"date_of_birth"`
+		PhoneNumber   string    `json:"phone_number"`
+		Address       string    `json:"address"`
+		EmailVerified bool      `json:"email_verified"`
+		CreatedAt     time.Time `json:"created_at"`
+		UpdatedAt     time.Time `json:"updated_at"`
+	}
+	query := "SELECT UserID, Name, Email, DateOfBirth, PhoneNumber, Address, EmailVerified, CreatedAt, UpdatedAt FROM Users WHERE UserID = ?"
+	err := db.QueryRow(query, caller.ID).Scan(&profile.UserID, &profile.Name, &profile.Email, &profile.DateOfBirth,
+		&profile.PhoneNumber, &profile.Address, &profile.EmailVerified, &profile.CreatedAt, &profile.UpdatedAt)
+	if err == sql.ErrNoRows {
+		http.Error(w, `{"message":"User not found"}`, http.StatusNotFound)
+		return
+	} else if err != nil {
+		log.Println("Database query error:", err)
+		http.Error(w, `{"message":"Internal server error"}`, http.StatusInternalServerError)
+		return
+	}
+
+	profileJSON, err := json.Marshal(profile)
+	if err != nil {
+		log.Println("JSON encoding error:", err)
+		http.Error(w, `{"message":"Internal server error"}`, http.StatusInternalServerError)
+		return
+	}
+
+	// A partial export would look complete to the patient, so fail if any service can't be reached
+	sections := map[string]json.RawMessage{"profile": profileJSON}
+	order := []string{"profile"}
+	token := auth.BearerToken(r)
+	for _, service := range userDataServices {
+		data, err := callUserDataService(service.ExportMethod, service.ExportURL, token)
+		if err != nil {
+			log.Println("Data export error:", err)
+			http.Error(w, fmt.Sprintf(`{"message":"Failed to collect %s data, please try again later"}`, service.Name), http.StatusBadGateway)
+			return
+		}
+		sections[service.Name] = data
+		order = append(order, service.Name)
+	}
+
+	exportedAt := time.Now().UTC().Format(time.RFC3339)
+	filename := "my-data-" + strconv.Itoa(caller.ID)
+	w.Header().Set("Cache-Control", "no-store")
+
+	if format == "zip" {
+		w.Header().Set("Content-Type", "application/zip")
+		w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`.zip"`)
+		archive := zip.NewWriter(w)
+		for _, name := range order {
+			f, err := archive.Create(name + ".json")
+			if err != nil {
+				log.Println("ZIP write error:", err)
+				return
+			}
+			f.Write(sections[name])
+		}
+		if f, err := archive.Create("README.txt"); err == nil {
+			fmt.Fprintf(f, "Personal data export for user %d, created %s.\nEach JSON file holds the records kept by one service.\n", caller.ID, exportedAt)
+		}
+		if err := archive.Close(); err != nil {
+			log.Println("ZIP write error:", err)
+		}
+		return
+	}
+
+	response := map[string]interface{}{"exported_at": exportedAt}
+	for name, data := range sections {
+		response[name] = data
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`.json"`)
+	json.NewEncoder(w).Encode(response)
+}
+
+// Permanently delete the authenticated patient's account. Records in the other services are
+// deleted first; if any of them fails the account is kept so the request can be retried.
+func deleteAccountHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
+	var request struct {
+		Password string `json:"password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		log.Println("JSON decoding error:", err)
+		http.Error(w, `{"message":"Invalid input"}`, http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	caller, _ := auth.CallerFromContext(r.Context())
+
+	// Confirm the password so a stolen session can't delete the account
+	var email, storedPassword string
+	err := db.QueryRow("SELECT Email, PasswordHash FROM Users WHERE UserID = ?", caller.ID).Scan(&email, &storedPassword)
+	if err == sql.ErrNoRows {
+		http.Error(w, `{"message":"User not found"}`, http.StatusNotFound)
+		return
+	} else if err != nil {
+		log.Println("Database query error:", err)
+		http.Error(w, `{"message":"Internal server error"}`, http.StatusInternalServerError)
+		return
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(storedPassword), []byte(request.Password)); err != nil {
+		http.Error(w, `{"message":"Password is incorrect"}`, http.StatusUnauthorized)
+		return
+	}
+
+	token := auth.BearerToken(r)
+	for _, service := range userDataServices {
+		if _, err := callUserDataService(http.MethodDelete, service.DeleteURL, token); err != nil {
+			log.Println("Data deletion error:", err)
+			http.Error(w, fmt.Sprintf(`{"message":"Failed to delete %s data, your account has not been deleted. Please try again later."}`, service.Name), http.StatusBadGateway)
+			return
+		}
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		log.Println("Database transaction error:", err)
+		http.Error(w, `{"message":"Internal server error"}`, http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	// Tokens, sessions and verification links are removed with the user by ON DELETE CASCADE.
+	// Security records keep the event but no longer name the email address.
+	subject := "deleted-user:" + strconv.Itoa(caller.ID)
+	lockoutSubject := auth.NormalizeEmail(email)
+	statements := []struct {
+		query string
+		args  []interface{}
+	}{
+		{"DELETE FROM Users WHERE UserID = ?", []interface{}{caller.ID}},
+		{"DELETE FROM LoginLockouts WHERE Kind = 'account' AND Subject = ?", []interface{}{lockoutSubject}},
+		{"UPDATE AuditLog SET Subject = ? WHERE Subject = ?", []interface{}{subject, lockoutSubject}},
+		{"INSERT IGNORE INTO RevokedTokens (TokenID, UserID, ExpiresAt) VALUES (?, ?, ?)", []interface{}{caller.TokenID, caller.ID, caller.ExpiresAt}},
+	}
+	for _, s := range statements {
+		if _, err := tx.Exec(s.query, s.args...); err != nil {
+			log.Println("Database update error:", err)
+			http.Error(w, `{"message":"Internal server error"}`, http.StatusInternalServerError)
+			return
+		}
+	}
+	if err := auth.WriteAudit(tx, "account_deleted", subject, auth.ClientIP(r), caller.ID, "Deleted by account holder"); err != nil {
+		log.Println("Audit log error:", err)
+		http.Error(w, `{"message":"Internal server error"}`, http.StatusInternalServerError)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		log.Println("Database commit error:", err)
+		http.Error(w, `{"message":"Internal server error"}`, http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("Deleted account for user %d\n", caller.ID)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Your account and all associated data have been deleted"})
+}
@@ -3,12 +3,16 @@ module self_assessment
 go 1.23.2
 
 require (
+	auth v0.0.0
 	github.com/go-sql-driver/mysql v1.8.1
 	github.com/gorilla/mux v1.8.1
 	github.com/rs/cors v1.11.1
+	golang.org/x/crypto v0.33.0
 )
 
 require (
 	filippo.io/edwards25519 v1.1.0 // indirect
-	golang.org/x/crypto v0.33.0 // indirect
+	github.com/golang-jwt/jwt/v5 v5.2.1 // indirect
 )
+
+replace auth => ../Auth
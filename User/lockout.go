@@ -0,0 +1,49 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"auth"
+)
+
+// bcrypt hash of a random string, compared against when a login names an unknown email
+const dummyPasswordHash = "$2a$10$2.qeZs.T3M8dz00S8JfQP.NtQmwqbZk9GICk1CJAJhWQstz.ZfREu"
+
+// Lift a login lockout on a patient account and/or client IP address. Admin only.
+func unlockAccountHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
+	var request struct {
+		Email     string `json:"email"`
+		IPAddress string `json:"ip_address"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		log.Println("JSON decoding error:", err)
+		http.Error(w, `{"message":"Invalid input"}`, http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	if request.Email == "" && request.IPAddress == "" {
+		http.Error(w, `{"message":"Email or ip_address is required"}`, http.StatusBadRequest)
+		return
+	}
+
+	caller, _ := auth.CallerFromContext(r.Context())
+	guard := auth.LoginGuard{DB: db}
+	cleared, err := guard.Unlock(request.Email, request.IPAddress, caller.ID, auth.ClientIP(r))
+	if err != nil {
+		log.Println("Unlock error:", err)
+		http.Error(w, `{"message":"Internal server error"}`, http.StatusInternalServerError)
+		return
+	}
+	if !cleared {
+		http.Error(w, `{"message":"No lockout found"}`, http.StatusNotFound)
+		return
+	}
+
+	log.Printf("Admin %d unlocked email=%q ip=%q\n", caller.ID, request.Email, request.IPAddress)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Lockout cleared"})
+}
@@ -10,6 +10,8 @@ import (
 	"regexp"
 	"time"
 
+	"auth"
+
 	_ "github.com/go-sql-driver/mysql"
 	"github.com/gorilla/mux"
 	"github.com/rs/cors"
@@ -33,6 +35,9 @@ func main() {
 		log.Fatal("Database credentials not fully set in environment variables")
 	}
 
+	// Get token signing secret from environment variables
+	jwtSecret = auth.SecretFromEnv()
+
 	// Get local port from environment variables
 	//localPort := os.Getenv("LOCAL_PORT")
 	localPort := "5001"
@@ -49,6 +54,7 @@ func main() {
 
 	// Initialize the router
 	router := mux.NewRouter()
+	verifier := newVerifier(db)
 
 	// API Routes
 	router.HandleFunc("/api/register", func(w http.ResponseWriter, r *http.Request) {
@@ -57,18 +63,51 @@ func main() {
 	router.HandleFunc("/api/authenticate", func(w http.ResponseWriter, r *http.Request) {
 		authenticationHandler(w, r, db)
 	}).Methods("POST")
-	router.HandleFunc("/api/getUserDetails", func(w http.ResponseWriter, r *http.Request) {
-		getUserDetailsHandler(w, r, db)
+	router.HandleFunc("/api/refreshToken", func(w http.ResponseWriter, r *http.Request) {
+		refreshTokenHandler(w, r, db)
+	}).Methods("POST")
+	router.HandleFunc("/api/logout", verifier.Require(func(w http.ResponseWriter, r *http.Request) {
+		logoutHandler(w, r, db)
+	})).Methods("POST")
+	router.HandleFunc("/api/forgotPassword", func(w http.ResponseWriter, r *http.Request) {
+		forgotPasswordHandler(w, r, db)
+	}).Methods("POST")
+	router.HandleFunc("/api/resetPassword", func(w http.ResponseWriter, r *http.Request) {
+		resetPasswordHandler(w, r, db)
+	}).Methods("POST")
+	router.HandleFunc("/api/changePassword", verifier.RequirePermission(auth.PermEditOwnProfile, func(w http.ResponseWriter, r *http.Request) {
+		changePasswordHandler(w, r, db)
+	})).Methods("PUT")
+	router.HandleFunc("/api/verifyEmail", func(w http.ResponseWriter, r *http.Request) {
+		verifyEmailHandler(w, r, db)
 	}).Methods("POST")
-	router.HandleFunc("/api/updateUserDetails", func(w http.ResponseWriter, r *http.Request) {
+	router.HandleFunc("/api/resendVerification", verifier.RequirePermission(auth.PermEditOwnProfile, func(w http.ResponseWriter, r *http.Request) {
+		resendVerificationHandler(w, r, db)
+	})).Methods("POST")
+	router.HandleFunc("/api/unlockAccount", verifier.RequirePermission(auth.PermUnlockAccounts, func(w http.ResponseWriter, r *http.Request) {
+		unlockAccountHandler(w, r, db)
+	})).Methods("POST")
+	router.HandleFunc("/api/getUserDetails", verifier.Require(func(w http.ResponseWriter, r *http.Request) {
+		getUserDetailsHandler(w, r, db)
+	})).Methods("POST")
+	router.HandleFunc("/api/getPatientAges", verifier.RequirePermission(auth.PermReadPatientRecords, func(w http.ResponseWriter, r *http.Request) {
+		getPatientAgesHandler(w, r, db)
+	})).Methods("POST")
+	router.HandleFunc("/api/updateUserDetails", verifier.RequirePermission(auth.PermEditOwnProfile, func(w http.ResponseWriter, r *http.Request) {
 		updateUserDetailsHandler(w, r, db)
-	}).Methods("PUT")
+	})).Methods("PUT")
+	router.HandleFunc("/api/exportMyData", verifier.RequirePermission(auth.PermManageOwnData, func(w http.ResponseWriter, r *http.Request) {
+		exportMyDataHandler(w, r, db)
+	})).Methods("GET")
+	router.HandleFunc("/api/deleteAccount", verifier.RequirePermission(auth.PermManageOwnData, func(w http.ResponseWriter, r *http.Request) {
+		deleteAccountHandler(w, r, db)
+	})).Methods("DELETE")
 
 	// CORS Configuration
 	c := cors.New(cors.Options{
 		AllowedOrigins:   []string{"*"},
-		AllowedMethods:   []string{"GET", "POST", "PUT"},
-		AllowedHeaders:   []string{"Content-Type"},
+		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE"},
+		AllowedHeaders:   []string{"Content-Type", "Authorization"},
 		AllowCredentials: true,
 	})
 	handler := c.Handler(router)
@@ -206,16 +245,23 @@ func registrationHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
 	// Insert new user into the database
 	query2 := "INSERT INTO Users(Name, Email, PasswordHash, DateOfBirth, PhoneNumber, Address) VALUES(?, ?, ?, ?, ?, ?)"
 	// Insert User into DB
-	_, err = db.Exec(query2, u.Name, u.Email, hashedPassword, u.DateOfBirth, u.PhoneNumber, u.Address)
+	result, err := db.Exec(query2, u.Name, u.Email, hashedPassword, u.DateOfBirth, u.PhoneNumber, u.Address)
 	if err != nil {
 		log.Println("Database insert error:", err)
 		http.Error(w, `{"message":"Internal server error"}`, http.StatusInternalServerError)
 		return
 	}
 
+	// Email a verification link. The account is created either way; the user can ask for a new link later.
+	if newUserID, err := result.LastInsertId(); err != nil {
+		log.Println("Error reading new user ID:", err)
+	} else if err := startEmailVerification(db, int(newUserID), u.Email, u.Name); err != nil {
+		log.Println("Email verification error:", err)
+	}
+
 	// Send success response
 	response := map[string]string{
-		"message": "Registration successful!.",
+		"message": "Registration successful! Please check your email to verify your address.",
 	}
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(response)
@@ -243,6 +289,20 @@ func authenticationHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
 		return
 	}
 
+	// Refuse attempts while the account or client IP is locked out
+	guard := auth.LoginGuard{DB: db}
+	ip := auth.ClientIP(r)
+	remaining, err := guard.Locked(request.Email, ip)
+	if err != nil {
+		log.Println("Lockout check error:", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if remaining > 0 {
+		auth.WriteLockedOut(w, remaining)
+		return
+	}
+
 	// Prepare SQL statement
 	var storedUserID int
 	var storedPassword string
@@ -250,58 +310,51 @@ func authenticationHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
 	query := "SELECT UserID, PasswordHash FROM Users WHERE Email = ?"
 
 	// Query the database for a user with the provided email
-	err := db.QueryRow(query, request.Email).Scan(&storedUserID, &storedPassword)
+	err = db.QueryRow(query, request.Email).Scan(&storedUserID, &storedPassword)
 	if err == sql.ErrNoRows {
-		// If no user is found
-		http.Error(w, "Invalid email", http.StatusUnauthorized)
-		return
+		// Unknown emails are checked against a dummy hash so they take as long as a wrong password
+		storedPassword = dummyPasswordHash
 	} else if err != nil {
 		log.Println("Database query error:", err)
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
 		return
 	}
 
-	// Compare entered password with stored password
+	// Compare entered password with stored password. The same error is returned for unknown
+	// emails so the response doesn't reveal which accounts exist.
 	err = bcrypt.CompareHashAndPassword([]byte(storedPassword), []byte(request.Password))
-	if err != nil {
-		http.Error(w, "Invalid password", http.StatusUnauthorized)
+	if err != nil || storedUserID == 0 {
+		if err := guard.RecordFailure(request.Email, ip); err != nil {
+			log.Println("Error recording failed login:", err)
+		}
+		http.Error(w, "Invalid email or password", http.StatusUnauthorized)
 		return
 	}
 
-	// Set response headers
-	w.Header().Set("Content-Type", "application/json")
-	w.Header().Set("X-Content-Type-Options", "nosniff")
-	w.Header().Set("X-Frame-Options", "DENY")
-	w.Header().Set("X-XSS-Protection", "1; mode=block")
-
-	// Respond with success message
-	response := map[string]interface{}{
-		"message": "Login successful",
-		"user_id": storedUserID, // Return User ID
-	}
-	if err := json.NewEncoder(w).Encode(response); err != nil {
-		log.Println("JSON encoding error:", err)
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	if err := guard.RecordSuccess(request.Email); err != nil {
+		log.Println("Error clearing failed logins:", err)
 	}
+
+	// Respond with a signed access token and refresh token
+	writeTokenResponse(w, db, storedUserID, "Login successful")
 }
 
 func getUserDetailsHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
-	// Decode the incoming JSON request body
+	// Patients always get their own profile; doctors name the patient in the body
 	var request struct {
 		UserID int `json:"user_id"`
 	}
-
-	decoder := json.NewDecoder(r.Body)
-	if err := decoder.Decode(&request); err != nil {
-		log.Println("JSON decoding error:", err)
-		http.Error(w, "Invalid input", http.StatusBadRequest)
-		return
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			log.Println("JSON decoding error:", err)
+			http.Error(w, "Invalid input", http.StatusBadRequest)
+			return
+		}
+		defer r.Body.Close()
 	}
-	defer r.Body.Close()
 
-	// Validate User ID
-	if request.UserID <= 0 {
-		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+	userID, ok := auth.PatientID(w, r, request.UserID)
+	if !ok {
 		return
 	}
 
@@ -312,10 +365,12 @@ func getUserDetailsHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
 		DateOfBirth time.Time `json:"date_of_birth"`
 		PhoneNumber string    `json:"phone_number"`
 		Address     string    `json:"address"`
+		// Unverified addresses may be mistyped, so alerts sent to them may never arrive
+		EmailVerified bool `json:"email_verified"`
 	}
 
-	query := "SELECT Name, Email, DateOfBirth, PhoneNumber, Address FROM Users WHERE UserID = ?"
-	err := db.QueryRow(query, request.UserID).Scan(&user.Name, &user.Email, &user.DateOfBirth, &user.PhoneNumber, &user.Address)
+	query := "SELECT Name, Email, DateOfBirth, PhoneNumber, Address, EmailVerified FROM Users WHERE UserID = ?"
+	err := db.QueryRow(query, userID).Scan(&user.Name, &user.Email, &user.DateOfBirth, &user.PhoneNumber, &user.Address, &user.EmailVerified)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			http.Error(w, "User not found", http.StatusNotFound)
@@ -339,7 +394,7 @@ func getUserDetailsHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
 	}
 }
 
-// receives user id and new email, dob, phone no. or address and updates record. returns update status
+// receives new email, dob, phone no. or address for the authenticated user and updates record. returns update status
 func updateUserDetailsHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
 	// Decode the incoming JSON request body
 	var u User
@@ -351,12 +406,9 @@ func updateUserDetailsHandler(w http.ResponseWriter, r *http.Request, db *sql.DB
 	}
 	defer r.Body.Close()
 
-	// Validate input
-	if u.UserID <= 0 {
-		log.Println("Error: Invalid user ID received")
-		http.Error(w, `{"message":"Invalid user ID"}`, http.StatusBadRequest)
-		return
-	}
+	// Only the authenticated patient's own record can be updated
+	caller, _ := auth.CallerFromContext(r.Context())
+	u.UserID = caller.ID
 	u.Password = "Placeholder"
 	validationErrors := validateUserInput(u)
 
@@ -364,6 +416,7 @@ func updateUserDetailsHandler(w http.ResponseWriter, r *http.Request, db *sql.DB
 	query := "SELECT UserID FROM Users WHERE Email = ?"
 	var storedUserID int
 	err := db.QueryRow(query, u.Email).Scan(&storedUserID)
+	emailChanged := err == sql.ErrNoRows
 	if err == sql.ErrNoRows {
 
 	} else if err != nil {
@@ -382,9 +435,9 @@ func updateUserDetailsHandler(w http.ResponseWriter, r *http.Request, db *sql.DB
 		return
 	}
 
-	// Prepare the SQL statement
-	query2 := "UPDATE Users SET Name=?, Email=?, DateOfBirth=?, PhoneNumber=?, Address=? WHERE UserID=?"
-	result, err := db.Exec(query2, u.Name, u.Email, u.DateOfBirth, u.PhoneNumber, u.Address, u.UserID)
+	// Prepare the SQL statement. A new email address has to be verified again.
+	query2 := "UPDATE Users SET Name=?, Email=?, DateOfBirth=?, PhoneNumber=?, Address=?, EmailVerified = EmailVerified AND NOT ? WHERE UserID=?"
+	result, err := db.Exec(query2, u.Name, u.Email, u.DateOfBirth, u.PhoneNumber, u.Address, emailChanged, u.UserID)
 	if err != nil {
 		log.Println("Database update error:", err)
 		http.Error(w, `{"message":"Internal server error"}`, http.StatusInternalServerError)
@@ -405,6 +458,12 @@ func updateUserDetailsHandler(w http.ResponseWriter, r *http.Request, db *sql.DB
 		return
 	}
 
+	if emailChanged {
+		if err := startEmailVerification(db, u.UserID, u.Email, u.Name); err != nil {
+			log.Println("Email verification error:", err)
+		}
+	}
+
 	// Send success response
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]string{"message": "Profile updated successfully"})
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"auth"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Password reset links expire after this long
+const resetTokenTTL = 30 * time.Minute
+
+// Validate a new password with the same rule used at registration
+func validatePassword(password string) string {
+	if len(password) < 8 {
+		return "Password must be at least 8 characters long"
+	}
+	return ""
+}
+
+// Call Email Service to send the reset link
+func sendPasswordResetEmail(email, name, token string) {
+	body, _ := json.Marshal(map[string]string{
+		"Email": email,
+		"Name":  name,
+		"Token": token,
+	})
+
+	resp, err := postAsService("http://localhost:8090/sendPasswordReset", body)
+	if err != nil {
+		log.Println("Failed to send password reset email:", err)
+		return
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		log.Printf("Email service returned status %d for password reset\n", resp.StatusCode)
+	}
+}
+
+// Start a password reset. The response is the same whether or not the email is registered,
+// so the endpoint can't be used to discover accounts.
+func forgotPasswordHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
+	var request struct {
+		Email string `json:"email"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		log.Println("JSON decoding error:", err)
+		http.Error(w, `{"message":"Invalid input"}`, http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	if request.Email == "" {
+		http.Error(w, `{"message":"Email is required"}`, http.StatusBadRequest)
+		return
+	}
+
+	response := map[string]string{
+		"message": "If an account exists for this email, a password reset link has been sent.",
+	}
+
+	var userID int
+	var name string
+	err := db.QueryRow("SELECT UserID, Name FROM Users WHERE Email = ?", request.Email).Scan(&userID, &name)
+	if err == sql.ErrNoRows {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(response)
+		return
+	} else if err != nil {
+		log.Println("Database query error:", err)
+		http.Error(w, `{"message":"Internal server error"}`, http.StatusInternalServerError)
+		return
+	}
+
+	token, err := randomToken(32)
+	if err != nil {
+		log.Println("Reset token generation error:", err)
+		http.Error(w, `{"message":"Internal server error"}`, http.StatusInternalServerError)
+		return
+	}
+
+	// Only the most recent reset link stays valid
+	if _, err := db.Exec("UPDATE PasswordResetTokens SET UsedAt = NOW() WHERE UserID = ? AND UsedAt IS NULL", userID); err != nil {
+		log.Println("Database update error:", err)
+		http.Error(w, `{"message":"Internal server error"}`, http.StatusInternalServerError)
+		return
+	}
+	query := "INSERT INTO PasswordResetTokens (TokenHash, UserID, ExpiresAt) VALUES (?, ?, ?)"
+	if _, err := db.Exec(query, hashToken(token), userID, time.Now().Add(resetTokenTTL)); err != nil {
+		log.Println("Database insert error:", err)
+		http.Error(w, `{"message":"Internal server error"}`, http.StatusInternalServerError)
+		return
+	}
+
+	go sendPasswordResetEmail(request.Email, name, token)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// Set a new password using a single-use reset token. All existing sessions are ended.
+func resetPasswordHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
+	var request struct {
+		Token       string `json:"token"`
+		NewPassword string `json:"new_password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		log.Println("JSON decoding error:", err)
+		http.Error(w, `{"message":"Invalid input"}`, http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	if request.Token == "" {
+		http.Error(w, `{"message":"Reset token is required"}`, http.StatusBadRequest)
+		return
+	}
+	if msg := validatePassword(request.NewPassword); msg != "" {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"password": msg})
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		log.Println("Database transaction error:", err)
+		http.Error(w, `{"message":"Internal server error"}`, http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	var userID int
+	var expiresAt time.Time
+	var usedAt sql.NullTime
+	query := "SELECT UserID, ExpiresAt, UsedAt FROM PasswordResetTokens WHERE TokenHash = ? FOR UPDATE"
+	err = tx.QueryRow(query, hashToken(request.Token)).Scan(&userID, &expiresAt, &usedAt)
+	if err == sql.ErrNoRows || (err == nil && (usedAt.Valid || time.Now().After(expiresAt))) {
+		http.Error(w, `{"message":"Reset link is invalid or has expired"}`, http.StatusBadRequest)
+		return
+	} else if err != nil {
+		log.Println("Database query error:", err)
+		http.Error(w, `{"message":"Internal server error"}`, http.StatusInternalServerError)
+		return
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(request.NewPassword), bcrypt.DefaultCost)
+	if err != nil {
+		log.Println("Password hashing error:", err)
+		http.Error(w, `{"message":"Internal server error"}`, http.StatusInternalServerError)
+		return
+	}
+
+	if _, err := tx.Exec("UPDATE Users SET PasswordHash = ? WHERE UserID = ?", hashedPassword, userID); err != nil {
+		log.Println("Database update error:", err)
+		http.Error(w, `{"message":"Internal server error"}`, http.StatusInternalServerError)
+		return
+	}
+	if _, err := tx.Exec("UPDATE PasswordResetTokens SET UsedAt = NOW() WHERE TokenHash = ?", hashToken(request.Token)); err != nil {
+		log.Println("Database update error:", err)
+		http.Error(w, `{"message":"Internal server error"}`, http.StatusInternalServerError)
+		return
+	}
+	if err := revokeAllRefreshTokens(tx, userID); err != nil {
+		log.Println("Database update error:", err)
+		http.Error(w, `{"message":"Internal server error"}`, http.StatusInternalServerError)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		log.Println("Database commit error:", err)
+		http.Error(w, `{"message":"Internal server error"}`, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Password has been reset. Please log in with your new password."})
+}
+
+// Change the authenticated user's password. The current password must be supplied,
+// and every other session is ended.
+func changePasswordHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
+	var request struct {
+		CurrentPassword string `json:"current_password"`
+		NewPassword     string `json:"new_password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		log.Println("JSON decoding error:", err)
+		http.Error(w, `{"message":"Invalid input"}`, http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	if msg := validatePassword(request.NewPassword); msg != "" {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"password": msg})
+		return
+	}
+
+	caller, _ := auth.CallerFromContext(r.Context())
+
+	var storedPassword string
+	err := db.QueryRow("SELECT PasswordHash FROM Users WHERE UserID = ?", caller.ID).Scan(&storedPassword)
+	if err == sql.ErrNoRows {
+		http.Error(w, `{"message":"User not found"}`, http.StatusNotFound)
+		return
+	} else if err != nil {
+		log.Println("Database query error:", err)
+		http.Error(w, `{"message":"Internal server error"}`, http.StatusInternalServerError)
+		return
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(storedPassword), []byte(request.CurrentPassword)); err != nil {
+		http.Error(w, `{"message":"Current password is incorrect"}`, http.StatusUnauthorized)
+		return
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(request.NewPassword), bcrypt.DefaultCost)
+	if err != nil {
+		log.Println("Password hashing error:", err)
+		http.Error(w, `{"message":"Internal server error"}`, http.StatusInternalServerError)
+		return
+	}
+
+	if _, err := db.Exec("UPDATE Users SET PasswordHash = ? WHERE UserID = ?", hashedPassword, caller.ID); err != nil {
+		log.Println("Database update error:", err)
+		http.Error(w, `{"message":"Internal server error"}`, http.StatusInternalServerError)
+		return
+	}
+	if err := revokeAllRefreshTokens(db, caller.ID); err != nil {
+		log.Println("Database update error:", err)
+		http.Error(w, `{"message":"Internal server error"}`, http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("Password changed for user %d\n", caller.ID)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Password changed successfully. Please log in again on your other devices."})
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"auth"
+)
+
+// Age in whole years on the given day
+func ageOn(dateOfBirth, day time.Time) int {
+	age := day.Year() - dateOfBirth.Year()
+	if day.Month() < dateOfBirth.Month() || (day.Month() == dateOfBirth.Month() && day.Day() < dateOfBirth.Day()) {
+		age--
+	}
+	return age
+}
+
+// Return the current age of several patients at once, for population statistics such as the
+// Self Assessment cohort analytics. Only ages are returned, and only for patients on the caller's
+// care team; others are left out.
+func getPatientAgesHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
+	var request struct {
+		UserIDs []int `json:"user_ids"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		log.Println("JSON decoding error:", err)
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	caller, _ := auth.CallerFromContext(r.Context())
+	assigned, err := caller.AssignedPatients()
+	if err != nil {
+		log.Println("Care team lookup error:", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	allowed := map[int]bool{}
+	for _, id := range assigned {
+		allowed[id] = true
+	}
+
+	placeholders := []string{}
+	args := []interface{}{}
+	for _, id := range request.UserIDs {
+		if allowed[id] {
+			placeholders = append(placeholders, "?")
+			args = append(args, id)
+		}
+	}
+
+	ages := map[int]int{}
+	if len(placeholders) > 0 {
+		query := "SELECT UserID, DateOfBirth FROM Users WHERE DateOfBirth IS NOT NULL AND UserID IN (" + strings.Join(placeholders, ", ") + ")"
+		rows, err := db.Query(query, args...)
+		if err != nil {
+			log.Println("Database query error:", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		defer rows.Close()
+
+		now := time.Now()
+		for rows.Next() {
+			var id int
+			var dateOfBirth time.Time
+			if err := rows.Scan(&id, &dateOfBirth); err != nil {
+				log.Println("Database scan error:", err)
+				http.Error(w, "Internal server error", http.StatusInternalServerError)
+				return
+			}
+			ages[id] = ageOn(dateOfBirth, now)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	json.NewEncoder(w).Encode(map[string]interface{}{"ages": ages})
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"auth"
+)
+
+// Token lifetimes
+const (
+	accessTokenTTL  = 15 * time.Minute
+	refreshTokenTTL = 7 * 24 * time.Hour
+)
+
+// Secret used to sign access tokens, loaded from JWT_SECRET in main
+var jwtSecret []byte
+
+// Generate a random hex string of n bytes
+func randomToken(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// Refresh tokens are only stored as SHA-256 hashes
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// Token verifier that also rejects access tokens revoked by logout
+func newVerifier(db *sql.DB) *auth.Verifier {
+	return &auth.Verifier{
+		Secret:      jwtSecret,
+		CareTeamURL: "http://localhost:5004",
+		IsRevoked: func(tokenID string) (bool, error) {
+			var revoked int
+			err := db.QueryRow("SELECT COUNT(*) FROM RevokedTokens WHERE TokenID = ?", tokenID).Scan(&revoked)
+			return revoked > 0, err
+		},
+	}
+}
+
+// POST JSON to another service on this service's own behalf, with a short-lived service token
+func postAsService(url string, body []byte) (*http.Response, error) {
+	token, err := auth.IssueServiceToken(jwtSecret, "user_service")
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+	return http.DefaultClient.Do(req)
+}
+
+// Create and store a new refresh token for the user
+func issueRefreshToken(db *sql.DB, userID int) (string, error) {
+	token, err := randomToken(32)
+	if err != nil {
+		return "", err
+	}
+
+	query := "INSERT INTO RefreshTokens (TokenHash, UserID, ExpiresAt) VALUES (?, ?, ?)"
+	if _, err := db.Exec(query, hashToken(token), userID, time.Now().Add(refreshTokenTTL)); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// Implemented by both *sql.DB and *sql.Tx
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+// Revoke every active refresh token for a user, ending all their sessions
+func revokeAllRefreshTokens(db execer, userID int) error {
+	_, err := db.Exec("UPDATE RefreshTokens SET RevokedAt = NOW() WHERE UserID = ? AND RevokedAt IS NULL", userID)
+	return err
+}
+
+// Issue an access/refresh token pair and write it to the response
+func writeTokenResponse(w http.ResponseWriter, db *sql.DB, userID int, message string) {
+	accessToken, err := auth.IssueToken(jwtSecret, userID, auth.RolePatient, accessTokenTTL)
+	if err != nil {
+		log.Println("Access token signing error:", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	refreshToken, err := issueRefreshToken(db, userID)
+	if err != nil {
+		log.Println("Refresh token insert error:", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	// Set response headers
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("X-Frame-Options", "DENY")
+	w.Header().Set("X-XSS-Protection", "1; mode=block")
+	w.Header().Set("Cache-Control", "no-store")
+
+	response := map[string]interface{}{
+		"message":       message,
+		"user_id":       userID,
+		"access_token":  accessToken,
+		"refresh_token": refreshToken,
+		"token_type":    "Bearer",
+		"expires_in":    int(accessTokenTTL.Seconds()),
+	}
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		log.Println("JSON encoding error:", err)
+	}
+}
+
+// Exchange a valid refresh token for a new token pair. The old refresh token is revoked (rotation).
+func refreshTokenHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
+	var request struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		log.Println("JSON decoding error:", err)
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	if request.RefreshToken == "" {
+		http.Error(w, "Refresh token is required", http.StatusBadRequest)
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		log.Println("Database transaction error:", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	var userID int
+	var expiresAt time.Time
+	var revokedAt sql.NullTime
+	query := "SELECT UserID, ExpiresAt, RevokedAt FROM RefreshTokens WHERE TokenHash = ? FOR UPDATE"
+	err = tx.QueryRow(query, hashToken(request.RefreshToken)).Scan(&userID, &expiresAt, &revokedAt)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Invalid refresh token", http.StatusUnauthorized)
+		return
+	} else if err != nil {
+		log.Println("Database query error:", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	if revokedAt.Valid {
+		// A revoked token being replayed means it may have been stolen, so end every session for this user
+		log.Printf("Revoked refresh token reused for user %d, revoking all sessions\n", userID)
+		if err := revokeAllRefreshTokens(tx, userID); err != nil {
+			log.Println("Database update error:", err)
+		} else if err := tx.Commit(); err != nil {
+			log.Println("Database commit error:", err)
+		}
+		http.Error(w, "Invalid refresh token", http.StatusUnauthorized)
+		return
+	}
+	if time.Now().After(expiresAt) {
+		http.Error(w, "Refresh token expired", http.StatusUnauthorized)
+		return
+	}
+
+	if _, err := tx.Exec("UPDATE RefreshTokens SET RevokedAt = NOW() WHERE TokenHash = ?", hashToken(request.RefreshToken)); err != nil {
+		log.Println("Database update error:", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		log.Println("Database commit error:", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	writeTokenResponse(w, db, userID, "Token refreshed")
+}
+
+// Revoke the caller's access token and refresh token
+func logoutHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
+	var request struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+	// Body is optional; without a refresh token only the access token is revoked
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			log.Println("JSON decoding error:", err)
+			http.Error(w, "Invalid input", http.StatusBadRequest)
+			return
+		}
+		defer r.Body.Close()
+	}
+
+	caller, _ := auth.CallerFromContext(r.Context())
+	userID := caller.ID
+
+	query := "INSERT IGNORE INTO RevokedTokens (TokenID, UserID, ExpiresAt) VALUES (?, ?, ?)"
+	if _, err := db.Exec(query, caller.TokenID, userID, caller.ExpiresAt); err != nil {
+		log.Println("Database insert error:", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	if request.RefreshToken != "" {
+		query := "UPDATE RefreshTokens SET RevokedAt = NOW() WHERE TokenHash = ? AND UserID = ? AND RevokedAt IS NULL"
+		if _, err := db.Exec(query, hashToken(request.RefreshToken), userID); err != nil {
+			log.Println("Database update error:", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Logged out successfully"})
+}
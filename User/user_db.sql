@@ -10,6 +10,68 @@ CREATE TABLE Users (
     DateOfBirth DATE,
     PhoneNumber VARCHAR(15),
     Address TEXT,
+    EmailVerified BOOLEAN NOT NULL DEFAULT FALSE,
+    EmailVerifiedAt TIMESTAMP NULL,
     CreatedAt TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
     UpdatedAt TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP
-);
\ No newline at end of file
+);
+
+-- Refresh tokens issued at login, stored as SHA-256 hashes
+CREATE TABLE RefreshTokens (
+    TokenHash CHAR(64) PRIMARY KEY,
+    UserID INT NOT NULL,
+    ExpiresAt TIMESTAMP NOT NULL,
+    RevokedAt TIMESTAMP NULL,
+    CreatedAt TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (UserID) REFERENCES Users(UserID) ON DELETE CASCADE
+);
+
+-- Access tokens (by JWT ID) revoked before expiry through logout
+CREATE TABLE RevokedTokens (
+    TokenID CHAR(32) PRIMARY KEY,
+    UserID INT NOT NULL,
+    ExpiresAt TIMESTAMP NOT NULL
+);
+
+-- Single-use password reset tokens, stored as SHA-256 hashes
+CREATE TABLE PasswordResetTokens (
+    TokenHash CHAR(64) PRIMARY KEY,
+    UserID INT NOT NULL,
+    ExpiresAt TIMESTAMP NOT NULL,
+    UsedAt TIMESTAMP NULL,
+    CreatedAt TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (UserID) REFERENCES Users(UserID) ON DELETE CASCADE
+);
+
+-- Single-use email verification tokens, tied to the address they were sent to
+CREATE TABLE EmailVerificationTokens (
+    TokenHash CHAR(64) PRIMARY KEY,
+    UserID INT NOT NULL,
+    Email VARCHAR(100) NOT NULL,
+    ExpiresAt TIMESTAMP NOT NULL,
+    UsedAt TIMESTAMP NULL,
+    CreatedAt TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (UserID) REFERENCES Users(UserID) ON DELETE CASCADE
+);
+
+-- Failed login tracking per account (email) and per client IP address
+CREATE TABLE LoginLockouts (
+    Kind ENUM('account', 'ip') NOT NULL,
+    Subject VARCHAR(100) NOT NULL,
+    FailedCount INT NOT NULL DEFAULT 0,
+    LockCount INT NOT NULL DEFAULT 0,
+    LastFailureAt TIMESTAMP NULL,
+    LockedUntil TIMESTAMP NULL,
+    PRIMARY KEY (Kind, Subject)
+);
+
+-- Security events such as lockouts and admin unlocks
+CREATE TABLE AuditLog (
+    AuditID INT AUTO_INCREMENT PRIMARY KEY,
+    Event VARCHAR(50) NOT NULL,
+    Subject VARCHAR(100) NOT NULL,
+    IPAddress VARCHAR(45) NOT NULL DEFAULT '',
+    ActorID INT NULL,
+    Details TEXT,
+    CreatedAt TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
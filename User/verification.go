@@ -0,0 +1,150 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"auth"
+)
+
+// Verification links expire after this long
+const verificationTokenTTL = 24 * time.Hour
+
+// Call Email Service to send the verification link
+func sendVerificationEmail(email, name, token string) {
+	body, _ := json.Marshal(map[string]string{
+		"Email": email,
+		"Name":  name,
+		"Token": token,
+	})
+
+	resp, err := postAsService("http://localhost:8090/sendEmailVerification", body)
+	if err != nil {
+		log.Println("Failed to send verification email:", err)
+		return
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		log.Printf("Email service returned status %d for email verification\n", resp.StatusCode)
+	}
+}
+
+// Create a new verification token for a user and email it. Earlier links stop working.
+func startEmailVerification(db *sql.DB, userID int, email, name string) error {
+	token, err := randomToken(32)
+	if err != nil {
+		return err
+	}
+
+	if _, err := db.Exec("UPDATE EmailVerificationTokens SET UsedAt = NOW() WHERE UserID = ? AND UsedAt IS NULL", userID); err != nil {
+		return err
+	}
+	query := "INSERT INTO EmailVerificationTokens (TokenHash, UserID, Email, ExpiresAt) VALUES (?, ?, ?, ?)"
+	if _, err := db.Exec(query, hashToken(token), userID, email, time.Now().Add(verificationTokenTTL)); err != nil {
+		return err
+	}
+
+	go sendVerificationEmail(email, name, token)
+	return nil
+}
+
+// Confirm an email address using a single-use verification token
+func verifyEmailHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
+	var request struct {
+		Token string `json:"token"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		log.Println("JSON decoding error:", err)
+		http.Error(w, `{"message":"Invalid input"}`, http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	if request.Token == "" {
+		http.Error(w, `{"message":"Verification token is required"}`, http.StatusBadRequest)
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		log.Println("Database transaction error:", err)
+		http.Error(w, `{"message":"Internal server error"}`, http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	var userID int
+	var email string
+	var expiresAt time.Time
+	var usedAt sql.NullTime
+	query := "SELECT UserID, Email, ExpiresAt, UsedAt FROM EmailVerificationTokens WHERE TokenHash = ? FOR UPDATE"
+	err = tx.QueryRow(query, hashToken(request.Token)).Scan(&userID, &email, &expiresAt, &usedAt)
+	if err == sql.ErrNoRows || (err == nil && (usedAt.Valid || time.Now().After(expiresAt))) {
+		http.Error(w, `{"message":"Verification link is invalid or has expired"}`, http.StatusBadRequest)
+		return
+	} else if err != nil {
+		log.Println("Database query error:", err)
+		http.Error(w, `{"message":"Internal server error"}`, http.StatusInternalServerError)
+		return
+	}
+
+	// The link only verifies the address it was sent to
+	result, err := tx.Exec("UPDATE Users SET EmailVerified = TRUE, EmailVerifiedAt = NOW() WHERE UserID = ? AND Email = ?", userID, email)
+	if err != nil {
+		log.Println("Database update error:", err)
+		http.Error(w, `{"message":"Internal server error"}`, http.StatusInternalServerError)
+		return
+	}
+	if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+		http.Error(w, `{"message":"Verification link is invalid or has expired"}`, http.StatusBadRequest)
+		return
+	}
+	if _, err := tx.Exec("UPDATE EmailVerificationTokens SET UsedAt = NOW() WHERE TokenHash = ?", hashToken(request.Token)); err != nil {
+		log.Println("Database update error:", err)
+		http.Error(w, `{"message":"Internal server error"}`, http.StatusInternalServerError)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		log.Println("Database commit error:", err)
+		http.Error(w, `{"message":"Internal server error"}`, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Email address verified successfully"})
+}
+
+// Send a fresh verification link to the authenticated user's current email
+func resendVerificationHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
+	caller, _ := auth.CallerFromContext(r.Context())
+
+	var name, email string
+	var verified bool
+	err := db.QueryRow("SELECT Name, Email, EmailVerified FROM Users WHERE UserID = ?", caller.ID).Scan(&name, &email, &verified)
+	if err == sql.ErrNoRows {
+		http.Error(w, `{"message":"User not found"}`, http.StatusNotFound)
+		return
+	} else if err != nil {
+		log.Println("Database query error:", err)
+		http.Error(w, `{"message":"Internal server error"}`, http.StatusInternalServerError)
+		return
+	}
+
+	if verified {
+		http.Error(w, `{"message":"Email address is already verified"}`, http.StatusConflict)
+		return
+	}
+
+	if err := startEmailVerification(db, caller.ID, email, name); err != nil {
+		log.Println("Email verification error:", err)
+		http.Error(w, `{"message":"Internal server error"}`, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Verification email sent"})
+}
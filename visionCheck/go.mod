@@ -3,13 +3,13 @@ module risk_assessment
 go 1.23.2
 
 require (
+	auth v0.0.0
 	github.com/go-sql-driver/mysql v1.8.1
-	github.com/gorilla/mux v1.8.1
-	github.com/rs/cors v1.11.1
 )
 
 require (
 	filippo.io/edwards25519 v1.1.0 // indirect
-	gopkg.in/alexcesaro/quotedprintable.v3 v3.0.0-20150716171945-2caba252f4dc // indirect
-	gopkg.in/gomail.v2 v2.0.0-20160411212932-81ebce5c23df // indirect
+	github.com/golang-jwt/jwt/v5 v5.2.1 // indirect
 )
+
+replace auth => ../Auth
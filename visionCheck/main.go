@@ -4,12 +4,11 @@ import (
 	"bytes"
 	"database/sql"
 	"encoding/json"
-	"fmt"
 	"log"
 	"net/http"
 	"strconv"
 
-	"gopkg.in/gomail.v2"
+	"auth"
 
 	_ "github.com/go-sql-driver/mysql"
 )
@@ -23,9 +22,13 @@ type VisionResult struct {
 }
 
 func main() {
-	http.HandleFunc("/postVisionResult", handlePostRequest)
-	http.HandleFunc("/getLatestResult", getLatestResult)
-	http.HandleFunc("/getAllVisionResults", getAllVisionResults)
+	verifier := &auth.Verifier{Secret: auth.SecretFromEnv(), CareTeamURL: "http://localhost:5004"}
+
+	http.HandleFunc("/postVisionResult", verifier.RequirePermission(auth.PermSubmitAssessment, handlePostRequest))
+	http.HandleFunc("/getLatestResult", verifier.Require(getLatestResult))
+	http.HandleFunc("/getAllVisionResults", verifier.Require(getAllVisionResults))
+	http.HandleFunc("/exportUserData", verifier.RequirePermission(auth.PermManageOwnData, exportUserData))
+	http.HandleFunc("/deleteUserData", verifier.RequirePermission(auth.PermManageOwnData, deleteUserData))
 
 	log.Println("Vision service running on port 8088")
 	log.Fatal(http.ListenAndServe(":8088", nil))
@@ -34,7 +37,7 @@ func main() {
 func handlePostRequest(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 	w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
-	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
 
 	if r.Method == http.MethodOptions {
 		w.WriteHeader(http.StatusOK)
@@ -53,6 +56,10 @@ func handlePostRequest(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Vision results are always recorded against the patient's own account
+	caller, _ := auth.CallerFromContext(r.Context())
+	result.UserID = caller.ID
+
 	db, err := sql.Open("mysql", "root:04D685362v98@tcp(127.0.0.1:3306)/vision_assessment_db")
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -69,7 +76,7 @@ func handlePostRequest(w http.ResponseWriter, r *http.Request) {
 
 	// Call Email Microservice if vision score is low
 	if result.LeftEyeScore <= 2 || result.RightEyeScore <= 2 {
-		go callEmailMicroservice(result)
+		go callEmailMicroservice(result, auth.BearerToken(r))
 	}
 
 	w.WriteHeader(http.StatusOK)
@@ -79,7 +86,7 @@ func handlePostRequest(w http.ResponseWriter, r *http.Request) {
 func getLatestResult(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 	w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
-	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
 
 	if r.Method == http.MethodOptions {
 		w.WriteHeader(http.StatusOK)
@@ -91,9 +98,18 @@ func getLatestResult(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	userID := r.URL.Query().Get("userID")
-	if userID == "" {
-		http.Error(w, "UserID is required", http.StatusBadRequest)
+	// Patients may omit userID; doctors must name the patient
+	requestedID := 0
+	if param := r.URL.Query().Get("userID"); param != "" {
+		id, err := strconv.Atoi(param)
+		if err != nil {
+			http.Error(w, "Invalid userID", http.StatusBadRequest)
+			return
+		}
+		requestedID = id
+	}
+	userID, ok := auth.PatientID(w, r, requestedID)
+	if !ok {
 		return
 	}
 
@@ -124,7 +140,7 @@ func getLatestResult(w http.ResponseWriter, r *http.Request) {
 func getAllVisionResults(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 	w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
-	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
 
 	if r.Method == http.MethodOptions {
 		w.WriteHeader(http.StatusOK)
@@ -136,9 +152,18 @@ func getAllVisionResults(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	userID := r.URL.Query().Get("userID")
-	if userID == "" {
-		http.Error(w, "UserID is required", http.StatusBadRequest)
+	// Patients may omit userID; doctors must name the patient
+	requestedID := 0
+	if param := r.URL.Query().Get("userID"); param != "" {
+		id, err := strconv.Atoi(param)
+		if err != nil {
+			http.Error(w, "Invalid userID", http.StatusBadRequest)
+			return
+		}
+		requestedID = id
+	}
+	userID, ok := auth.PatientID(w, r, requestedID)
+	if !ok {
 		return
 	}
 
@@ -178,19 +203,32 @@ func getAllVisionResults(w http.ResponseWriter, r *http.Request) {
 }
 
 // Call Email Microservice
-func callEmailMicroservice(result VisionResult) {
+func callEmailMicroservice(result VisionResult, token string) {
 	emailServiceURL := "http://localhost:8090/sendReportToDoctor"
 
 	// Convert result to JSON
 	requestBody, _ := json.Marshal(result)
 
-	// Send POST request to email microservice
-	resp, err := http.Post(emailServiceURL, "application/json", bytes.NewBuffer(requestBody))
+	// Send POST request to email microservice with the patient's token, so it can look up their care team
+	req, err := http.NewRequest(http.MethodPost, emailServiceURL, bytes.NewBuffer(requestBody))
+	if err != nil {
+		log.Println("Error sending report to email microservice:", err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
 		log.Println("Error sending report to email microservice:", err)
 		return
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode != http.StatusOK {
+		log.Printf("Email microservice rejected vision report for user %d with status %d\n", result.UserID, resp.StatusCode)
+		return
+	}
+
 	log.Println("Report successfully sent to email microservice")
 }